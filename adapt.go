@@ -0,0 +1,55 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import "context"
+
+// A BurstLimiter is a Limiter which fails, rather than waits,
+// when asked for more than Burst units at once. The Limiter
+// type of golang.org/x/time/rate is a BurstLimiter.
+type BurstLimiter interface {
+	Limiter
+	Burst() int
+}
+
+// AdaptBurstLimiter returns a Limiter which waits on l in
+// chunks of at most l.Burst() units, so that Readers, Writers,
+// Conns, and the other wrappers in this package may be paced by
+// an external engine which limits bursts, such as the Limiter
+// of golang.org/x/time/rate, however large their transfers:
+//
+//	xl := xrate.NewLimiter(1<<20, 64<<10)
+//	w := rate.NewWriter(w, rate.WithLimiter(rate.AdaptBurstLimiter(xl)))
+//
+// If waiting for a chunk fails, the units of the chunks
+// already waited for are not returned to l.
+func AdaptBurstLimiter(l BurstLimiter) Limiter {
+	return burstLimiter{l}
+}
+
+type burstLimiter struct {
+	l BurstLimiter
+}
+
+func (b burstLimiter) WaitN(ctx context.Context, n int) error {
+	burst := b.l.Burst()
+	if burst <= 0 {
+		// The limiter may still allow unlimited
+		// units, as x/time/rate does with an
+		// infinite limit.
+		return b.l.WaitN(ctx, n)
+	}
+	for n > 0 {
+		k := n
+		if k > burst {
+			k = burst
+		}
+		if err := b.l.WaitN(ctx, k); err != nil {
+			return err
+		}
+		n -= k
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import "math"
+
+// An Anomaly describes a period whose rate deviated
+// abnormally from the recent baseline.
+type Anomaly struct {
+	// Rate is the report for the anomalous period.
+	Rate Rate
+	// Baseline is the rate which was expected.
+	Baseline float64
+	// Score measures how far Rate.Rate deviated from
+	// Baseline, in units which depend on the Detector.
+	Score float64
+}
+
+// A Detector decides whether the rate reported for a period
+// is anomalous. A Detector is only ever used by a single
+// Monitor, and only from its reporting goroutine, so
+// implementations need not be safe for concurrent use.
+type Detector interface {
+	Detect(r Rate) (a Anomaly, ok bool)
+}
+
+// WithDetector makes the Monitor pass each rate it reports
+// to d, and call f with any anomalies it detects. f is called
+// from the Monitor's goroutine before the rate is reported.
+// f may be nil, if d records the anomalies itself.
+func WithDetector(d Detector, f func(a Anomaly)) MonitorOption {
+	return func(m *Monitor) {
+		m.detector = d
+		m.anomaly = f
+	}
+}
+
+type zscore struct {
+	rates     []float64
+	i, n      int
+	threshold float64
+}
+
+// ZScoreDetector returns a Detector which compares each rate
+// to the mean and standard deviation of the previous n rates,
+// and reports an anomaly when it is more than threshold
+// standard deviations away from the mean. No anomalies are
+// reported until n rates have been seen. To avoid reporting
+// insignificant deviations from a very steady rate, the
+// standard deviation is taken to be at least 1% of the mean.
+// The Score of each Anomaly is its z-score.
+func ZScoreDetector(n int, threshold float64) Detector {
+	if n < 2 {
+		n = 2
+	}
+	return &zscore{rates: make([]float64, n), threshold: threshold}
+}
+
+func (z *zscore) Detect(r Rate) (a Anomaly, ok bool) {
+	if z.n == len(z.rates) {
+		var mean, sd float64
+		for _, v := range z.rates {
+			mean += v
+		}
+		mean /= float64(len(z.rates))
+		for _, v := range z.rates {
+			sd += (v - mean) * (v - mean)
+		}
+		sd = math.Sqrt(sd / float64(len(z.rates)-1))
+		if sd < mean/100 {
+			sd = mean / 100
+		}
+		if sd > 0 {
+			score := (r.Rate - mean) / sd
+			a, ok = Anomaly{Rate: r, Baseline: mean, Score: score}, math.Abs(score) > z.threshold
+		}
+	} else {
+		z.n++
+	}
+	z.rates[z.i] = r.Rate
+	z.i = (z.i + 1) % len(z.rates)
+	return a, ok
+}
+
+type change struct {
+	prev      float64
+	seen      bool
+	threshold float64
+}
+
+// ChangeDetector returns a Detector which reports an anomaly
+// whenever the rate changes from one period to the next by
+// more than the given fraction of the previous rate (for
+// example, 0.5 for a change of more than 50%). The Score of
+// each Anomaly is the fractional change, which is negative
+// if the rate dropped.
+func ChangeDetector(threshold float64) Detector {
+	return &change{threshold: threshold}
+}
+
+func (c *change) Detect(r Rate) (a Anomaly, ok bool) {
+	if c.seen && c.prev > 0 {
+		score := (r.Rate - c.prev) / c.prev
+		a, ok = Anomaly{Rate: r, Baseline: c.prev, Score: score}, math.Abs(score) > c.threshold
+	}
+	c.prev, c.seen = r.Rate, true
+	return a, ok
+}
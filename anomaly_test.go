@@ -0,0 +1,42 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"testing"
+	"time"
+)
+
+// alwaysDetector reports every rate as an anomaly,
+// and counts them.
+type alwaysDetector struct{ n chan struct{} }
+
+func (d alwaysDetector) Detect(r Rate) (Anomaly, bool) {
+	select {
+	case d.n <- struct{}{}:
+	default:
+	}
+	return Anomaly{Rate: r}, true
+}
+
+// TestDetectorNilFunc tests that WithDetector
+// may be given a nil function.
+func TestDetectorNilFunc(t *testing.T) {
+	d := alwaysDetector{make(chan struct{}, 1)}
+	rates := make(chan Rate, 1)
+	m := NewMonitor(WithPeriod(time.Millisecond), WithDetector(d, nil), WithChannel(rates))
+	defer m.Close()
+	m.Add(1)
+	select {
+	case <-d.n:
+	case <-time.After(5 * time.Second):
+		t.Fatal("detector was not called")
+	}
+	select {
+	case <-rates:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no rate was reported")
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+)
+
+// EntryProgress describes the progress made reading
+// a single entry of an archive.
+type EntryProgress struct {
+	// Index is the index of the entry in the archive,
+	// starting from 0.
+	Index int
+	Name  string
+	// Size is the size of the entry, and Read is the
+	// number of bytes of it read so far.
+	Size, Read int64
+	// Done is set when the entry has been read in full,
+	// or skipped.
+	Done bool
+}
+
+// A TarReader reads a tar archive, monitoring both the rate
+// at which the archive's bytes are read and the rate at
+// which its entries are, and reporting the progress made
+// on each entry. It is used like a *tar.Reader.
+type TarReader struct {
+	r     *Reader
+	tr    *tar.Reader
+	m     *Monitor
+	f     func(p EntryProgress)
+	cur   EntryProgress
+	valid bool // whether cur describes an entry
+}
+
+// NewTarReader returns a new TarReader which reads a tar
+// archive from r. The bytes read from r are counted by bytes,
+// and the entries by entries. If f is non-nil, it is called
+// with the progress of the current entry after every read
+// from it, and once the entry is done. Any of bytes, entries,
+// and f may be nil.
+func NewTarReader(r io.Reader, bytes, entries *Monitor, f func(p EntryProgress)) *TarReader {
+	mr := NewReader(r, WithMonitor(bytes))
+	return &TarReader{r: mr, tr: tar.NewReader(mr), m: entries, f: f}
+}
+
+// Next advances to the next entry in the archive, as
+// tar.Reader.Next does, and counts it.
+func (t *TarReader) Next() (*tar.Header, error) {
+	t.finish()
+	hdr, err := t.tr.Next()
+	if err != nil {
+		return hdr, err
+	}
+	t.m.Add(1)
+	t.cur = EntryProgress{Index: t.cur.Index, Name: hdr.Name, Size: hdr.Size}
+	if t.valid {
+		t.cur.Index++
+	}
+	t.valid = true
+	return hdr, nil
+}
+
+// Read reads from the current entry.
+func (t *TarReader) Read(p []byte) (n int, err error) {
+	n, err = t.tr.Read(p)
+	if t.valid && n > 0 {
+		t.cur.Read += int64(n)
+		t.report()
+	}
+	if err == io.EOF {
+		t.finish()
+	}
+	return
+}
+
+// finish reports the current entry as done,
+// if it has not been already.
+func (t *TarReader) finish() {
+	if t.valid && !t.cur.Done {
+		t.cur.Done = true
+		t.report()
+	}
+}
+
+func (t *TarReader) report() {
+	if t.f != nil {
+		t.f(t.cur)
+	}
+}
+
+// WalkZip calls fn for each file in the zip archive z, with
+// a Reader from which its decompressed contents may be read,
+// monitoring both the rate at which the decompressed bytes are
+// read and the rate at which the files are, and reporting the
+// progress made on each file, as NewTarReader does. If fn
+// returns an error, WalkZip stops and returns it.
+func WalkZip(z *zip.Reader, bytes, entries *Monitor, f func(p EntryProgress), fn func(zf *zip.File, r io.Reader) error) error {
+	for i, zf := range z.File {
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		entries.Add(1)
+		e := &zipEntry{
+			r: NewReader(rc, WithMonitor(bytes)),
+			f: f,
+			cur: EntryProgress{
+				Index: i,
+				Name:  zf.Name,
+				Size:  int64(zf.UncompressedSize64),
+			},
+		}
+		err = fn(zf, e)
+		e.finish()
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type zipEntry struct {
+	r   *Reader
+	f   func(p EntryProgress)
+	cur EntryProgress
+}
+
+func (e *zipEntry) Read(p []byte) (n int, err error) {
+	n, err = e.r.Read(p)
+	if n > 0 {
+		e.cur.Read += int64(n)
+		e.report()
+	}
+	if err == io.EOF {
+		e.finish()
+	}
+	return
+}
+
+func (e *zipEntry) finish() {
+	if !e.cur.Done {
+		e.cur.Done = true
+		e.report()
+	}
+}
+
+func (e *zipEntry) report() {
+	if e.f != nil {
+		e.f(e.cur)
+	}
+}
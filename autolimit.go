@@ -0,0 +1,129 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// defaultAutoInterval is the default interval
+// at which an AutoLimiter probes.
+const defaultAutoInterval = 10 * time.Minute
+
+// An AutoLimiter is a Pacer whose rate is set automatically
+// to a fraction of the capacity of the path it limits, as
+// measured periodically. It is intended for background
+// traffic, such as backups or sync, which should use as much
+// bandwidth as it can without saturating the user's link.
+// A rate set with SetRate lasts only until the next probe.
+type AutoLimiter struct {
+	*Pacer
+
+	fraction float64
+	interval time.Duration
+	probe    func() (float64, error)
+
+	mu   sync.Mutex
+	err  error
+	exit chan struct{}
+}
+
+// NewAutoLimiter returns a new AutoLimiter whose rate is
+// initially bps. It calls probe immediately, and then every
+// interval, to measure the capacity of the path in bytes per
+// second, and sets its rate to fraction (such as 0.8) of the
+// result. probe will usually be a call to Probe:
+//
+//	l := rate.NewAutoLimiter(64<<10, 0.8, 10*time.Minute, func() (float64, error) {
+//		p, err := rate.Probe(conn, filler, 4<<20, 2*time.Second)
+//		return p.Rate, err
+//	})
+//
+// If interval is not positive, ten minutes is used. If probe
+// returns an error, or a capacity which is not a number, or
+// is negative or infinite, the rate is left unchanged, and
+// the error is returned by Err. Since traffic limited by the
+// AutoLimiter competes with the probe, probe should pause
+// that traffic, or measure over a separate path, in order not
+// to underestimate the capacity.
+func NewAutoLimiter(bps uint64, fraction float64, interval time.Duration, probe func() (float64, error), opts ...LimitOption) *AutoLimiter {
+	if interval <= 0 {
+		interval = defaultAutoInterval
+	}
+	a := &AutoLimiter{
+		Pacer:    NewPacer(bps, opts...),
+		fraction: fraction,
+		interval: interval,
+		probe:    probe,
+		exit:     make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *AutoLimiter) run() {
+	t := time.NewTicker(a.interval)
+	defer t.Stop()
+	for {
+		a.measure()
+		select {
+		case <-a.exit:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+var errBadCapacity = errors.New("rate: probe returned an invalid capacity")
+
+// measure probes the capacity of the path
+// and sets a's rate accordingly.
+func (a *AutoLimiter) measure() {
+	c, err := a.probe()
+	if err == nil && (math.IsNaN(c) || math.IsInf(c, 0) || c < 0) {
+		err = errBadCapacity
+	}
+	a.mu.Lock()
+	a.err = err
+	a.mu.Unlock()
+	if err != nil {
+		return
+	}
+	var bps uint64
+	switch v := c * a.fraction; {
+	case math.IsNaN(v) || v < 1:
+		// Never stop the traffic altogether on
+		// account of a bad measurement.
+		bps = 1
+	case v >= float64(Inf):
+		bps = Inf
+	default:
+		bps = uint64(v)
+	}
+	a.SetRate(bps)
+}
+
+// Err returns the error returned by the most
+// recent probe, if any.
+func (a *AutoLimiter) Err() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+// Close stops a from probing. Its rate remains
+// as last set.
+func (a *AutoLimiter) Close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	select {
+	case <-a.exit:
+	default:
+		close(a.exit)
+	}
+}
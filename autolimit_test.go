@@ -0,0 +1,47 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// TestAutoLimiterBadInput tests that an AutoLimiter survives
+// a non-positive interval and invalid probe results.
+func TestAutoLimiterBadInput(t *testing.T) {
+	for _, c := range []float64{math.NaN(), math.Inf(1), math.Inf(-1), -1} {
+		probed := make(chan struct{}, 1)
+		a := NewAutoLimiter(1000, 0.8, 0, func() (float64, error) {
+			select {
+			case probed <- struct{}{}:
+			default:
+			}
+			return c, nil
+		})
+		<-probed
+		// Let the probe's result be applied.
+		for i := 0; i < 100 && a.Err() == nil; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		a.Close()
+		if a.Err() == nil {
+			t.Errorf("capacity %v: got no error", c)
+		}
+		if got := a.Rate(); got != 1000 {
+			t.Errorf("capacity %v: got rate %v; want 1000", c, got)
+		}
+	}
+
+	a := NewAutoLimiter(1000, 1e30, 0, func() (float64, error) { return 1e30, nil })
+	for i := 0; i < 100 && a.Rate() == 1000; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	a.Close()
+	if got := a.Rate(); got != Inf {
+		t.Errorf("got rate %v; want Inf", got)
+	}
+}
@@ -0,0 +1,370 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// A ring is a bounded buffer which is filled by one
+// goroutine and drained by another.
+type ring struct {
+	mu   sync.Mutex
+	cond *sync.Cond // broadcast whenever the state changes
+	buf  []byte
+	r, n int   // start and length of the buffered bytes
+	err  error // set once the producer is done
+	done bool  // set once the consumer is done
+	derr error // the error, if any, which stopped the consumer
+}
+
+func newRing(size int) *ring {
+	b := &ring{buf: make([]byte, size)}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// free returns the index of the start of the largest
+// contiguous free region of b.buf which follows the
+// buffered bytes, and the index of its end. b.mu
+// must be held.
+func (b *ring) free() (w, end int) {
+	w, end = (b.r+b.n)%len(b.buf), len(b.buf)
+	if w < b.r {
+		end = b.r
+	}
+	return w, end
+}
+
+// readFrom fills b from src until src returns
+// an error or the consumer is done.
+func (b *ring) readFrom(src io.Reader) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		for b.n == len(b.buf) && !b.done {
+			b.cond.Wait()
+		}
+		if b.done {
+			return
+		}
+		// Read without holding the lock, so that
+		// the consumer may drain the buffer
+		// concurrently; the regions touched by the
+		// two never overlap.
+		w, end := b.free()
+		b.mu.Unlock()
+		n, err := src.Read(b.buf[w:end])
+		b.mu.Lock()
+		b.n += n
+		if err != nil {
+			b.err = err
+		}
+		b.cond.Broadcast()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Read drains b, blocking until bytes are buffered
+// or the producer is done.
+func (b *ring) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.n == 0 && b.err == nil && !b.done {
+		b.cond.Wait()
+	}
+	if b.n == 0 {
+		err = b.err
+		if b.done {
+			err = io.ErrClosedPipe
+		}
+		return
+	}
+	end := b.r + b.n
+	if end > len(b.buf) {
+		end = len(b.buf)
+	}
+	n = copy(p, b.buf[b.r:end])
+	b.r = (b.r + n) % len(b.buf)
+	b.n -= n
+	b.cond.Broadcast()
+	return
+}
+
+// Write fills b from p, blocking while b is full. If
+// the consumer is done, Write returns the error which
+// stopped it, and if the producer is, io.ErrClosedPipe.
+func (b *ring) Write(p []byte) (n int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(p) > 0 {
+		for b.n == len(b.buf) && !b.done {
+			b.cond.Wait()
+		}
+		if b.err != nil && !b.done {
+			err = io.ErrClosedPipe
+			return
+		}
+		if b.done {
+			err = b.derr
+			if err == nil {
+				err = io.ErrClosedPipe
+			}
+			return
+		}
+		w, end := b.free()
+		k := copy(b.buf[w:end], p)
+		b.n += k
+		n += k
+		p = p[k:]
+		b.cond.Broadcast()
+	}
+	return
+}
+
+// writeTo drains b to dst until the producer is done
+// and b is empty, or dst returns an error. Bytes are
+// not removed from b until they have been written, so
+// b is empty only once everything has been written.
+func (b *ring) writeTo(dst io.Writer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		for b.n == 0 && b.err == nil && !b.done {
+			b.cond.Wait()
+		}
+		if b.n == 0 || b.done {
+			return
+		}
+		// Write in small chunks, so that bytes are
+		// freed as they are written at a limited rate.
+		end := b.r + b.n
+		if end > len(b.buf) {
+			end = len(b.buf)
+		}
+		if end-b.r > limitChunk {
+			end = b.r + limitChunk
+		}
+		chunk := b.buf[b.r:end]
+		b.mu.Unlock()
+		n, err := dst.Write(chunk)
+		b.mu.Lock()
+		b.r = (b.r + n) % len(b.buf)
+		b.n -= n
+		if err != nil {
+			b.derr, b.done = err, true
+		}
+		b.cond.Broadcast()
+	}
+}
+
+// flush blocks until b is empty or the consumer is
+// done, and returns the error which stopped it, if any.
+// If ctx is done first, flush returns ctx.Err().
+func (b *ring) flush(ctx context.Context) error {
+	if ctx.Done() != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				b.mu.Lock()
+				b.cond.Broadcast()
+				b.mu.Unlock()
+			case <-stop:
+			}
+		}()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.n > 0 && !b.done {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		b.cond.Wait()
+	}
+	return b.derr
+}
+
+// closeWrite marks the producer as done.
+func (b *ring) closeWrite() {
+	b.mu.Lock()
+	if b.err == nil {
+		b.err = io.EOF
+	}
+	b.cond.Broadcast()
+	b.mu.Unlock()
+}
+
+// buffered returns the number of buffered bytes.
+func (b *ring) buffered() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.n
+}
+
+// stop marks the consumer as done, waking
+// the producer if it is blocked.
+func (b *ring) stop() {
+	b.mu.Lock()
+	b.done = true
+	b.cond.Broadcast()
+	b.mu.Unlock()
+}
+
+// A ReadAheadReader reads from a bursty source ahead of time
+// into a bounded buffer, and serves its consumer from the
+// buffer at a steady, limited rate. Sinks which need smooth
+// input, such as media players and tape drives, can read
+// through a ReadAheadReader to ride out stutters in the
+// source, so long as the buffer does not run dry.
+type ReadAheadReader struct {
+	*Reader
+	b   *ring
+	src io.Reader
+}
+
+// NewReadAheadReader returns a new ReadAheadReader which reads
+// from r into a buffer of size bytes in a separate goroutine.
+// The rate at which bytes are read from the buffer is limited
+// and monitored according to opts, as for NewReader; the rate
+// at which the buffer is filled is not limited.
+func NewReadAheadReader(r io.Reader, size int, opts ...Option) *ReadAheadReader {
+	if size <= 0 {
+		size = 4096
+	}
+	b := newRing(size)
+	go b.readFrom(r)
+	return &ReadAheadReader{Reader: NewReader(ReaderOnly{b}, opts...), b: b, src: r}
+}
+
+// Buffered returns the number of bytes which have
+// been read ahead, and have yet to be consumed.
+func (r *ReadAheadReader) Buffered() int {
+	return r.b.buffered()
+}
+
+// Fill returns the fraction of the buffer, between
+// 0 and 1, which is filled. A Fill which trends
+// towards 0 means the source cannot keep up with
+// the limited rate.
+func (r *ReadAheadReader) Fill() float64 {
+	return float64(r.b.buffered()) / float64(len(r.b.buf))
+}
+
+// Close closes the reader and stops reading ahead.
+// If the underlying Reader implements io.ReadCloser,
+// its Close method is called, and its return value
+// is returned, which also serves to interrupt a read
+// from it which is in progress.
+func (r *ReadAheadReader) Close() error {
+	r.Reader.Close()
+	r.b.stop()
+	if rc, ok := r.src.(io.ReadCloser); ok {
+		return rc.Close()
+	}
+	return nil
+}
+
+// A WriteBehindWriter accepts bursts of writes into a bounded
+// buffer immediately, and drains the buffer to the underlying
+// Writer at a limited rate in a separate goroutine. This
+// decouples the latency seen by the producer from the shaping
+// of its output: Write only blocks while the buffer is full.
+//
+// Since bytes are written to the underlying Writer after
+// Write returns, errors from it are returned by subsequent
+// calls to Write, and by Flush and Close.
+type WriteBehindWriter struct {
+	b      *ring
+	w      io.Writer
+	cancel context.CancelFunc // interrupts a wait for the limiter
+	exited chan struct{}      // closed once the buffer stops draining
+}
+
+// NewWriteBehindWriter returns a new WriteBehindWriter which
+// buffers up to size bytes, and drains them to w. The rate at
+// which the buffer is drained is limited and monitored
+// according to opts, as for NewWriter.
+func NewWriteBehindWriter(w io.Writer, size int, opts ...Option) *WriteBehindWriter {
+	if size <= 0 {
+		size = 4096
+	}
+	b := newRing(size)
+	lw := NewWriter(WriterOnly{w}, opts...)
+	// Let DrainAndClose interrupt a wait for the limiter,
+	// so that nothing is written to w once it returns.
+	ctx, cancel := context.WithCancel(lw.context())
+	lw.ctx = ctx
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		b.writeTo(lw)
+	}()
+	return &WriteBehindWriter{b: b, w: w, cancel: cancel, exited: exited}
+}
+
+// Write copies p into the buffer, blocking only while
+// the buffer is full. If writing to the underlying
+// Writer has failed, Write returns the error.
+func (w *WriteBehindWriter) Write(p []byte) (n int, err error) {
+	n, err = w.b.Write(p)
+	return
+}
+
+// Buffered returns the number of bytes which have been
+// written but not yet drained to the underlying Writer.
+func (w *WriteBehindWriter) Buffered() int {
+	return w.b.buffered()
+}
+
+// Flush blocks until all buffered bytes have been written
+// to the underlying Writer, and returns the error which
+// stopped the writing, if any.
+func (w *WriteBehindWriter) Flush() error {
+	return w.b.flush(context.Background())
+}
+
+// Close flushes the buffer, and stops the goroutine which
+// drains it. It returns the error, if any, encountered
+// while draining the buffer; otherwise, if the underlying
+// Writer implements io.WriteCloser, its Close method is
+// called, and its return value is returned.
+func (w *WriteBehindWriter) Close() error {
+	_, err := w.DrainAndClose(context.Background())
+	return err
+}
+
+// DrainAndClose closes w gracefully: it stops accepting
+// writes, lets the bytes already buffered drain to the
+// underlying Writer at the limited rate until they have all
+// been written or ctx is done, and then closes w as Close
+// does. It returns the number of bytes drained, and the
+// first error encountered, which is ctx.Err() if ctx was
+// done before the buffer was empty. A write to the
+// underlying Writer which is in progress when ctx is done
+// is not interrupted; DrainAndClose waits for it to finish.
+func (w *WriteBehindWriter) DrainAndClose(ctx context.Context) (n int64, err error) {
+	w.b.closeWrite()
+	before := w.b.buffered()
+	err = w.b.flush(ctx)
+	w.b.stop()
+	w.cancel()
+	<-w.exited
+	n = int64(before - w.b.buffered())
+	if wc, ok := w.w.(io.WriteCloser); ok {
+		if cerr := wc.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return
+}
@@ -0,0 +1,127 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"errors"
+	"io"
+)
+
+// byteBatch is the number of bytes read or written one at
+// a time which are limited and counted together.
+const byteBatch = 4 << 10
+
+// takeByte ensures that s has budget for another byte
+// read or written one at a time, limiting and counting
+// such bytes in batches, so that it is cheap to do so.
+func (s *stream) takeByte() error {
+	if s.bn < s.bk {
+		return nil
+	}
+	if err := s.flushBytes(); err != nil {
+		return err
+	}
+	k, err := s.take(byteBatch, true)
+	if err != nil {
+		return err
+	}
+	s.bk = k
+	return nil
+}
+
+// flushBytes limits and counts the bytes read or
+// written one at a time since the last batch.
+func (s *stream) flushBytes() error {
+	if s.bk == 0 {
+		return nil
+	}
+	k, n := s.bk, s.bn
+	s.bk, s.bn = 0, 0
+	return s.done(k, n, true)
+}
+
+// ReadByte implements io.ByteReader, so that m may be given
+// to decoders which require one. If m's underlying Reader
+// implements io.ByteReader, its ReadByte method is used;
+// otherwise, ReadByte reads a single byte with Read. Bytes
+// read with ReadByte are limited and counted in batches of
+// 4KB, so they may not be reflected in m's Monitor, nor
+// waited for, until later.
+func (m *Reader) ReadByte() (byte, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	br, ok := m.r.(io.ByteReader)
+	if !ok {
+		var b [1]byte
+		n, err := io.ReadFull(m, b[:])
+		if n == 0 {
+			return 0, err
+		}
+		return b[0], nil
+	}
+	if err := m.takeByte(); err != nil {
+		return 0, err
+	}
+	c, err := br.ReadByte()
+	if err != nil {
+		m.failed(err)
+		if ferr := m.flushBytes(); ferr != nil && err == io.EOF {
+			err = ferr
+		}
+		return 0, err
+	}
+	m.bn++
+	return c, nil
+}
+
+var errNoUnreadByte = errors.New("rate: underlying Reader does not support UnreadByte")
+
+// UnreadByte implements io.ByteScanner, if m's underlying
+// Reader does. It returns an error otherwise.
+func (m *Reader) UnreadByte() error {
+	bs, ok := m.r.(io.ByteScanner)
+	if !ok {
+		return errNoUnreadByte
+	}
+	if err := bs.UnreadByte(); err != nil {
+		return err
+	}
+	// If the byte has already been counted,
+	// it will be counted again when it is
+	// read again.
+	if m.bn > 0 {
+		m.bn--
+	}
+	return nil
+}
+
+// WriteByte implements io.ByteWriter, so that m may be given
+// to encoders which require one. If m's underlying Writer
+// implements io.ByteWriter, its WriteByte method is used;
+// otherwise, WriteByte writes a single byte with Write. Bytes
+// written with WriteByte are limited and counted in batches
+// of 4KB, so they may not be reflected in m's Monitor, nor
+// waited for, until later.
+func (m *Writer) WriteByte(c byte) error {
+	if m.err != nil {
+		return m.err
+	}
+	bw, ok := m.w.(io.ByteWriter)
+	if !ok {
+		_, err := m.Write([]byte{c})
+		return err
+	}
+	if err := m.takeByte(); err != nil {
+		return err
+	}
+	if err := bw.WriteByte(c); err != nil {
+		m.failed(err)
+		m.flushBytes()
+		return err
+	}
+	m.bn++
+	return nil
+}
@@ -0,0 +1,27 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import "time"
+
+// A Clock tells the time and sleeps. By default, limiters
+// use the system clock; other implementations may be
+// supplied with WithClock in order to run limiters in
+// simulated time (see package ratetest).
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time        { return time.Now() }
+func (systemClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// WithClock makes the limiter use c rather than the
+// system clock.
+func WithClock(c Clock) LimitOption {
+	return func(p *Pacer) { p.clock = c }
+}
@@ -0,0 +1,263 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command ratesocks is a SOCKS5 proxy which shapes the
+// traffic passing through it. It applies per-connection
+// and aggregate bandwidth limits in each direction, and
+// can add latency, so that any application which supports
+// SOCKS5 can be observed on a constrained network.
+//
+// Usage:
+//
+//	ratesocks [flags]
+//
+// Rates are in bytes per second; a rate of 0 means no limit.
+// For example, to emulate a slow, high-latency uplink:
+//
+//	ratesocks -listen localhost:1080 -conn-up 65536 -latency 150ms
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/joshlf/rate"
+)
+
+var (
+	listen    = flag.String("listen", "localhost:1080", "address to listen on")
+	connUp    = flag.Uint64("conn-up", 0, "per-connection limit from client to target")
+	connDown  = flag.Uint64("conn-down", 0, "per-connection limit from target to client")
+	totalUp   = flag.Uint64("total-up", 0, "aggregate limit from clients to targets")
+	totalDown = flag.Uint64("total-down", 0, "aggregate limit from targets to clients")
+	latency   = flag.Duration("latency", 0, "latency added in each direction")
+)
+
+// aggregate limiters, shared by all connections
+var up, down *rate.Pacer
+
+func main() {
+	flag.Parse()
+	up, down = rate.NewPacer(bps(*totalUp)), rate.NewPacer(bps(*totalDown))
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("listening on %v", l.Addr())
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			log.Fatal(err)
+		}
+		go serve(c)
+	}
+}
+
+// bps converts a rate given on the command line,
+// where 0 means no limit, to a rate.
+func bps(n uint64) uint64 {
+	if n == 0 {
+		return rate.Inf
+	}
+	return n
+}
+
+func serve(c net.Conn) {
+	defer c.Close()
+	target, err := handshake(c)
+	if err != nil {
+		log.Printf("%v: %v", c.RemoteAddr(), err)
+		return
+	}
+	defer target.Close()
+	log.Printf("%v: connected to %v", c.RemoteAddr(), target.RemoteAddr())
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		relay(target, c, up, *connUp)
+	}()
+	go func() {
+		defer wg.Done()
+		relay(c, target, down, *connDown)
+	}()
+	wg.Wait()
+}
+
+// relay copies from src to dst, limited by both the
+// aggregate limiter and a per-connection limit.
+func relay(dst, src net.Conn, aggregate *rate.Pacer, perConn uint64) {
+	w := rate.NewWriter(rate.NewWriter(dst, rate.WithLimit(bps(perConn))), rate.WithLimiter(aggregate))
+	var err error
+	if *latency > 0 {
+		err = delay(w, src, *latency)
+	} else {
+		_, err = io.Copy(w, src)
+	}
+	if err != nil {
+		log.Printf("%v: %v", src.RemoteAddr(), err)
+	}
+	if cw, ok := dst.(interface {
+		CloseWrite() error
+	}); ok {
+		cw.CloseWrite()
+	}
+}
+
+type chunk struct {
+	b   []byte
+	due time.Time
+}
+
+// delay copies from src to dst, delaying each chunk
+// by d without otherwise limiting throughput.
+func delay(dst io.Writer, src io.Reader, d time.Duration) error {
+	ch := make(chan chunk, 64)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(ch)
+		for {
+			b := make([]byte, 32<<10)
+			n, err := src.Read(b)
+			if n > 0 {
+				ch <- chunk{b[:n], time.Now().Add(d)}
+			}
+			if err != nil {
+				if err != io.EOF {
+					errc <- err
+				}
+				return
+			}
+		}
+	}()
+	for c := range ch {
+		time.Sleep(time.Until(c.due))
+		if _, err := dst.Write(c.b); err != nil {
+			// Unblock the reader.
+			go func() {
+				for range ch {
+				}
+			}()
+			return err
+		}
+	}
+	select {
+	case err := <-errc:
+		return err
+	default:
+		return nil
+	}
+}
+
+const (
+	socks5 = 5
+
+	cmdConnect = 1
+
+	atypIPv4   = 1
+	atypDomain = 3
+	atypIPv6   = 4
+
+	repSuccess         = 0
+	repFailure         = 1
+	repCmdUnsupported  = 7
+	repAtypUnsupported = 8
+)
+
+var errVersion = errors.New("unsupported SOCKS version")
+
+// handshake performs the SOCKS5 handshake on c, which
+// supports only unauthenticated CONNECT requests, and
+// returns the connection to the requested target.
+func handshake(c net.Conn) (net.Conn, error) {
+	var buf [256]byte
+	if _, err := io.ReadFull(c, buf[:2]); err != nil {
+		return nil, err
+	}
+	if buf[0] != socks5 {
+		return nil, errVersion
+	}
+	methods := buf[:buf[1]]
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return nil, err
+	}
+	noAuth := false
+	for _, m := range methods {
+		noAuth = noAuth || m == 0
+	}
+	if !noAuth {
+		c.Write([]byte{socks5, 0xff})
+		return nil, errors.New("client does not support unauthenticated access")
+	}
+	if _, err := c.Write([]byte{socks5, 0}); err != nil {
+		return nil, err
+	}
+
+	if _, err := io.ReadFull(c, buf[:4]); err != nil {
+		return nil, err
+	}
+	if buf[0] != socks5 {
+		return nil, errVersion
+	}
+	if buf[1] != cmdConnect {
+		reply(c, repCmdUnsupported)
+		return nil, errors.New("unsupported command " + strconv.Itoa(int(buf[1])))
+	}
+	var host string
+	switch buf[3] {
+	case atypIPv4, atypIPv6:
+		ip := make(net.IP, 4)
+		if buf[3] == atypIPv6 {
+			ip = make(net.IP, 16)
+		}
+		if _, err := io.ReadFull(c, ip); err != nil {
+			return nil, err
+		}
+		host = ip.String()
+	case atypDomain:
+		if _, err := io.ReadFull(c, buf[:1]); err != nil {
+			return nil, err
+		}
+		name := buf[1 : 1+buf[0]]
+		if _, err := io.ReadFull(c, name); err != nil {
+			return nil, err
+		}
+		host = string(name)
+	default:
+		reply(c, repAtypUnsupported)
+		return nil, errors.New("unsupported address type")
+	}
+	if _, err := io.ReadFull(c, buf[:2]); err != nil {
+		return nil, err
+	}
+	port := binary.BigEndian.Uint16(buf[:2])
+
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		reply(c, repFailure)
+		return nil, err
+	}
+	if err := reply(c, repSuccess); err != nil {
+		target.Close()
+		return nil, err
+	}
+	return target, nil
+}
+
+// reply sends a reply with the given code. The bound
+// address is always reported as 0.0.0.0:0, which
+// clients ignore for CONNECT requests.
+func reply(c net.Conn, code byte) error {
+	_, err := c.Write([]byte{socks5, code, 0, atypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,105 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command ratetop displays the Monitors registered in another
+// process, sorted by rate, in the manner of iftop. The process
+// must serve rate.RegistryHandler:
+//
+//	http.Handle("/debug/rate", rate.RegistryHandler())
+//
+// Usage:
+//
+//	ratetop [flags] url
+//
+// For example:
+//
+//	ratetop -n 10 http://localhost:6060/debug/rate
+//
+// Programs which want to display their own Monitors
+// can use rate.Registered directly.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joshlf/rate"
+)
+
+var (
+	interval = flag.Duration("interval", time.Second, "refresh interval")
+	limit    = flag.Int("n", 0, "show at most this many monitors (0 for all)")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: ratetop [flags] url\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	url := flag.Arg(0)
+
+	for {
+		entries, err := fetch(url)
+		if err != nil {
+			log.Fatal(err)
+		}
+		render(entries)
+		time.Sleep(*interval)
+	}
+}
+
+func fetch(url string) ([]rate.Entry, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: unexpected status %s", url, resp.Status)
+	}
+	var entries []rate.Entry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("%s: %v", url, err)
+	}
+	return entries, nil
+}
+
+// render clears the terminal and draws entries,
+// from the fastest to the slowest.
+func render(entries []rate.Entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Rate.Rate > entries[j].Rate.Rate
+	})
+	var sum float64
+	for _, e := range entries {
+		sum += e.Rate.Rate
+	}
+	if *limit > 0 && len(entries) > *limit {
+		entries = entries[:*limit]
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	fmt.Fprint(w, "\x1b[H\x1b[2J")
+	fmt.Fprintf(w, "ratetop - %s - total %s/s\n\n", time.Now().Format("15:04:05"), rate.FormatBytes(sum))
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "RATE\tTOTAL\t  NAME\n")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s/s\t%s\t  %s\n", rate.FormatBytes(e.Rate.Rate), rate.FormatBytes(float64(e.Rate.Total)), e.Name)
+	}
+	tw.Flush()
+}
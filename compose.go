@@ -0,0 +1,161 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"context"
+	"sync"
+)
+
+type composite struct {
+	ls []Limiter
+
+	mu sync.Mutex
+	// credit is the number of units for which every
+	// Limiter of ls which cannot return units has been
+	// charged, but which were not used.
+	credit int
+}
+
+// Compose returns a Limiter which allows units to be consumed
+// only when all of ls allow it, so that layered policies (for
+// example, per-tenant, per-endpoint, and global limits) can be
+// enforced together. Units are waited for from all of ls at
+// once, so that those taken from one are held only until the
+// others allow them, and if any of them fails (because ctx is
+// done), the units taken from the others are returned, so that
+// they are not lost. Units can only be returned to *Pacers
+// (and Limiters returned by Compose); units taken from other
+// Limiters are kept by the returned Limiter, and used before
+// those Limiters are waited for again, if they were not used
+// because of a short read or another of ls, and otherwise
+// lost.
+func Compose(ls ...Limiter) Limiter {
+	return &composite{ls: ls}
+}
+
+func (c *composite) WaitN(ctx context.Context, n int) error {
+	for got := 0; got < n; {
+		k, err := c.take(ctx, n-got)
+		if err != nil {
+			c.refund(got)
+			return err
+		}
+		got += k
+	}
+	return nil
+}
+
+func (c *composite) take(ctx context.Context, max int) (int, error) {
+	n := max
+	plain := false
+	for _, l := range c.ls {
+		if _, ok := l.(taker); !ok {
+			plain = true
+			if n > limitChunk {
+				n = limitChunk
+			}
+		}
+	}
+	// Units which the Limiters that cannot return
+	// them have already allowed are used first.
+	var used int
+	if plain {
+		c.mu.Lock()
+		used = c.credit
+		if used > n {
+			used = n
+		}
+		c.credit -= used
+		c.mu.Unlock()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		got  = make([]int, len(c.ls))
+		errs = make([]error, len(c.ls))
+		mu   sync.Mutex
+		err  error // the first error
+		wg   sync.WaitGroup
+	)
+	wait := func(i int) {
+		if t, ok := c.ls[i].(taker); ok {
+			got[i], errs[i] = t.take(ctx, n)
+		} else {
+			got[i] = n
+			if used < n {
+				errs[i] = c.ls[i].WaitN(ctx, n-used)
+			}
+		}
+		if errs[i] != nil {
+			mu.Lock()
+			if err == nil {
+				err = errs[i]
+				// The others need not wait.
+				cancel()
+			}
+			mu.Unlock()
+		}
+	}
+	for i := range c.ls {
+		if i == len(c.ls)-1 {
+			wait(i)
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			wait(i)
+		}(i)
+	}
+	wg.Wait()
+
+	k := 0
+	if err == nil {
+		k = n
+		for _, g := range got {
+			if g < k {
+				k = g
+			}
+		}
+	}
+	// Return the units which were taken,
+	// but not allowed by all of ls.
+	for i, l := range c.ls {
+		if t, ok := l.(taker); ok && errs[i] == nil {
+			t.refund(got[i] - k)
+		}
+	}
+	if plain {
+		c.mu.Lock()
+		if err != nil {
+			c.credit += used
+		} else {
+			c.credit += n - k
+		}
+		c.mu.Unlock()
+	}
+	return k, err
+}
+
+func (c *composite) refund(n int) {
+	if n <= 0 {
+		return
+	}
+	plain := false
+	for _, l := range c.ls {
+		if t, ok := l.(taker); ok {
+			t.refund(n)
+		} else {
+			plain = true
+		}
+	}
+	if plain {
+		c.mu.Lock()
+		c.credit += n
+		c.mu.Unlock()
+	}
+}
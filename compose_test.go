@@ -0,0 +1,99 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// sleepLimiter is a Limiter which
+// waits for the same time for any units.
+type sleepLimiter time.Duration
+
+func (d sleepLimiter) WaitN(ctx context.Context, n int) error {
+	t := time.NewTimer(time.Duration(d))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TestComposeWaitsAtOnce tests that a composite Limiter
+// waits for its Limiters at once rather than in turn, so
+// that its wait is as long as the longest of theirs, and
+// that it returns the units taken from the others when
+// one fails.
+func TestComposeWaitsAtOnce(t *testing.T) {
+	p := NewPacer(Inf)
+	c := Compose(p, sleepLimiter(200*time.Millisecond), sleepLimiter(300*time.Millisecond))
+	start := time.Now()
+	if err := c.WaitN(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 450*time.Millisecond {
+		t.Errorf("waited %v for Limiters which wait 200ms and 300ms; want about 300ms", d)
+	}
+
+	p = NewPacer(1000)
+	c = Compose(p, sleepLimiter(time.Hour))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.WaitN(ctx, 100); err != context.DeadlineExceeded {
+		t.Errorf("got error %v; want %v", err, context.DeadlineExceeded)
+	}
+	if !p.AllowN(time.Now(), 100) {
+		t.Error("units taken from a Pacer were not returned")
+	}
+}
+
+// countLimiter is a Limiter which never waits,
+// and counts the units waited for.
+type countLimiter struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (l *countLimiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	l.n += n
+	l.mu.Unlock()
+	return nil
+}
+
+// TestComposeShortReads tests that a Reader limited by a
+// composite Limiter is charged by Limiters which cannot
+// return units only for the bytes it reads.
+func TestComposeShortReads(t *testing.T) {
+	const size = 10000
+	var l countLimiter
+	r := NewReader(iotest.OneByteReader(strings.NewReader(strings.Repeat("x", size))),
+		WithLimiter(Compose(NewPacer(Inf), &l)))
+	buf := make([]byte, 1000)
+	var n int
+	for {
+		k, err := r.Read(buf)
+		n += k
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n != size {
+		t.Fatalf("read %d bytes; want %d", n, size)
+	}
+	if l.n > size+1000 {
+		t.Errorf("charged for %d units after reading %d bytes; want at most %d", l.n, size, size+1000)
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// A Conn wraps a net.Conn, limiting or monitoring the
+// rates at which bytes are read from and written to it.
+// All methods other than Read and Write are passed
+// through to the underlying connection.
+//
+// A Conn counts the bytes read from and written to the
+// connection it wraps. To measure the plaintext bytes
+// of a TLS connection, wrap the *tls.Conn, and use the
+// TLS method to access its TLS-specific methods. To
+// measure the bytes on the wire, wrap the underlying
+// connection and create the *tls.Conn on top of it:
+//
+//	c := rate.NewLimitConn(raw, rbps, wbps)
+//	tc := tls.Client(c, config)
+type Conn struct {
+	net.Conn
+	r io.Reader
+	w io.Writer
+}
+
+// NewLimitConn returns a new Conn which reads from c at a
+// maximum rate of readBPS bytes per second, and writes to c
+// at a maximum rate of writeBPS bytes per second, as though
+// by NewLimitReader and NewLimitWriter.
+func NewLimitConn(c net.Conn, readBPS, writeBPS uint64, opts ...LimitOption) *Conn {
+	return NewConn(c, []Option{WithLimit(readBPS, opts...)}, []Option{WithLimit(writeBPS, opts...)})
+}
+
+// NewMonitorConn returns a new Conn which counts bytes read
+// from c on read, and bytes written to c on write. Either
+// of read and write may be nil, in which case the bytes in
+// that direction are not counted. Closing the Conn does not
+// close the monitors.
+func NewMonitorConn(c net.Conn, read, write *Monitor) *Conn {
+	return NewConn(c, []Option{WithMonitor(read)}, []Option{WithMonitor(write)})
+}
+
+// NewConn returns a new Conn which reads from c through a
+// Reader configured with readOpts, and writes to c through
+// a Writer configured with writeOpts, so that each direction
+// may be given any Limiter, Monitor, or other Option.
+func NewConn(c net.Conn, readOpts, writeOpts []Option) *Conn {
+	return &Conn{
+		Conn: c,
+		r:    NewReader(c, append(readOpts[:len(readOpts):len(readOpts)], withConnDeadline())...),
+		w:    NewWriter(c, append(writeOpts[:len(writeOpts):len(writeOpts)], withConnDeadline())...),
+	}
+}
+
+func (c *Conn) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	return
+}
+
+func (c *Conn) Write(p []byte) (n int, err error) {
+	n, err = c.w.Write(p)
+	return
+}
+
+// SetIdleTimeout makes reads and writes fail with
+// ErrIdleTimeout if they make no progress for d, as though
+// by WithIdleTimeout. Time spent waiting for the limiter
+// does not count. If d == 0, there is no idle timeout.
+// It must not be called concurrently with Read or Write.
+func (c *Conn) SetIdleTimeout(d time.Duration) {
+	if r, ok := c.r.(*Reader); ok {
+		r.idle = d
+	}
+	if w, ok := c.w.(*Writer); ok {
+		w.idle = d
+	}
+}
+
+// SetDeadline sets the read and write deadlines of the
+// underlying connection. If c has an idle timeout, reads
+// and writes fail at whichever of the deadline and the
+// idle timeout comes first.
+//
+// The deadlines also apply to time spent waiting for c's
+// limiters, as they would to a read or write blocked on
+// the network: once a deadline passes, a read or write
+// which is waiting fails with an error whose Timeout
+// method returns true, and a deadline set while a read or
+// write is waiting applies to it at once.
+func (c *Conn) SetDeadline(t time.Time) error {
+	rerr := c.SetReadDeadline(t)
+	if werr := c.SetWriteDeadline(t); rerr == nil {
+		rerr = werr
+	}
+	return rerr
+}
+
+// SetReadDeadline sets the read deadline of the
+// underlying connection, as SetDeadline does.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	if r, ok := c.r.(*Reader); ok {
+		r.user.Store(t)
+		r.conn.set(t)
+	}
+	return c.Conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the
+// underlying connection, as SetDeadline does.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	if w, ok := c.w.(*Writer); ok {
+		w.user.Store(t)
+		w.conn.set(t)
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
+
+type deadlineError struct{}
+
+func (deadlineError) Error() string   { return "rate: i/o timeout" }
+func (deadlineError) Timeout() bool   { return true }
+func (deadlineError) Temporary() bool { return true }
+
+// errDeadline is returned by the reads and writes of a
+// Conn when its deadline passes while they are waiting
+// for its limiter.
+var errDeadline net.Error = deadlineError{}
+
+// A connDeadline holds the deadline of one direction of
+// a Conn, so that it can interrupt waits for its limiter.
+type connDeadline struct {
+	mu     sync.Mutex
+	t      time.Time
+	cancel context.CancelFunc // cancels the current wait, if any
+	gen    uint64             // incremented whenever a wait is interrupted
+}
+
+// withConnDeadline makes the Reader or Writer
+// subject to the deadlines of a Conn.
+func withConnDeadline() Option {
+	return func(s *stream) { s.conn = new(connDeadline) }
+}
+
+// set sets the deadline, and interrupts the current
+// wait, if any, so that it waits again with the new
+// deadline. It may be called on a nil *connDeadline.
+func (d *connDeadline) set(t time.Time) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.t = t
+	if d.cancel != nil {
+		d.cancel()
+		d.cancel = nil
+		d.gen++
+	}
+}
+
+// wait returns a context derived from ctx for a wait
+// which is subject to the deadline, a function which
+// ends the wait and reports whether set interrupted it,
+// and the deadline.
+func (d *connDeadline) wait(ctx context.Context) (context.Context, func() bool, time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var cancel context.CancelFunc
+	if d.t.IsZero() {
+		ctx, cancel = context.WithCancel(ctx)
+	} else {
+		ctx, cancel = context.WithDeadline(ctx, d.t)
+	}
+	d.cancel = cancel
+	gen := d.gen
+	return ctx, func() bool {
+		d.mu.Lock()
+		d.cancel = nil
+		interrupted := d.gen != gen
+		d.mu.Unlock()
+		cancel()
+		return interrupted
+	}, d.t
+}
+
+var errNoCloseWrite = errors.New("rate: underlying connection does not support CloseWrite")
+
+// CloseWrite shuts down the writing side of the underlying
+// connection, if it supports doing so (as *net.TCPConn and
+// *tls.Conn do). Otherwise, it returns an error.
+func (c *Conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface {
+		CloseWrite() error
+	}); ok {
+		return cw.CloseWrite()
+	}
+	return errNoCloseWrite
+}
+
+var errNoSyscallConn = errors.New("rate: underlying connection does not implement syscall.Conn")
+
+// SyscallConn returns a raw network connection for the
+// underlying connection, so that socket options can be set
+// after it has been wrapped. If the underlying connection
+// does not implement syscall.Conn, but exposes a connection
+// of its own through a NetConn method (as *tls.Conn does as
+// of Go 1.18), that connection is used instead.
+func (c *Conn) SyscallConn() (syscall.RawConn, error) {
+	nc := c.Conn
+	for {
+		if sc, ok := nc.(syscall.Conn); ok {
+			return sc.SyscallConn()
+		}
+		u, ok := nc.(interface {
+			NetConn() net.Conn
+		})
+		if !ok {
+			return nil, errNoSyscallConn
+		}
+		nc = u.NetConn()
+	}
+}
+
+// TLS returns a TLSConn which exposes the TLS-specific methods
+// of c's underlying connection, or nil if it is not a *tls.Conn.
+func (c *Conn) TLS() *TLSConn {
+	tc, ok := c.Conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	return &TLSConn{Conn: c, tc: tc}
+}
+
+// A TLSConn is a Conn which wraps a *tls.Conn, and which
+// measures the plaintext bytes read from and written to it.
+// It exposes the TLS-specific methods of the *tls.Conn so
+// that code which inspects the state of the connection
+// through type assertions continues to work.
+type TLSConn struct {
+	*Conn
+	tc *tls.Conn
+}
+
+// ConnectionState returns basic TLS details about the connection.
+func (c *TLSConn) ConnectionState() tls.ConnectionState { return c.tc.ConnectionState() }
+
+// Handshake runs the client or server handshake protocol if
+// it has not yet been run.
+func (c *TLSConn) Handshake() error { return c.tc.Handshake() }
+
+// VerifyHostname checks that the peer certificate chain is
+// valid for connecting to host.
+func (c *TLSConn) VerifyHostname(host string) error { return c.tc.VerifyHostname(host) }
+
+// OCSPResponse returns the stapled OCSP response from the
+// TLS server, if any.
+func (c *TLSConn) OCSPResponse() []byte { return c.tc.OCSPResponse() }
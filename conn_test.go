@@ -0,0 +1,100 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestConnDeadline tests that deadlines interrupt reads
+// and writes which are waiting for a Conn's limiters,
+// whether they are set before or during the wait.
+func TestConnDeadline(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		set    func(c *Conn, t time.Time) error
+		during bool
+		read   bool
+	}{
+		{"read", (*Conn).SetReadDeadline, false, true},
+		{"read during", (*Conn).SetReadDeadline, true, true},
+		{"write", (*Conn).SetWriteDeadline, false, false},
+		{"write during", (*Conn).SetWriteDeadline, true, false},
+		{"both read", (*Conn).SetDeadline, true, true},
+		{"both write", (*Conn).SetDeadline, true, false},
+	} {
+		a, b := net.Pipe()
+		go io.Copy(ioutil.Discard, b)
+		go func() {
+			buf := make([]byte, 1024)
+			for {
+				if _, err := b.Write(buf); err != nil {
+					return
+				}
+			}
+		}()
+		conn := NewLimitConn(a, 100, 100)
+		buf := make([]byte, 1024)
+		// Use up the first quantum's budget.
+		if c.read {
+			io.ReadFull(conn, buf[:10])
+		} else {
+			conn.Write(buf[:10])
+		}
+
+		start := time.Now()
+		if !c.during {
+			c.set(conn, start.Add(50*time.Millisecond))
+		} else {
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				c.set(conn, time.Now())
+			}()
+		}
+		var err error
+		if c.read {
+			_, err = io.ReadFull(conn, buf)
+		} else {
+			_, err = conn.Write(buf)
+		}
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Errorf("%s: got error %v; want a timeout", c.name, err)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("%s: returned after %v; want about 50ms", c.name, d)
+		}
+		a.Close()
+		b.Close()
+	}
+}
+
+// noReadDeadlineConn is a net.Conn which does
+// not support read deadlines.
+type noReadDeadlineConn struct {
+	net.Conn
+}
+
+var errNoReadDeadline = errors.New("no read deadline")
+
+func (noReadDeadlineConn) SetReadDeadline(time.Time) error {
+	return errNoReadDeadline
+}
+
+// TestConnSetDeadlineError tests that SetDeadline returns
+// the error of setting the read deadline.
+func TestConnSetDeadlineError(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	conn := NewLimitConn(noReadDeadlineConn{a}, 100, 100)
+	if err := conn.SetDeadline(time.Now()); err != errNoReadDeadline {
+		t.Errorf("got error %v; want %v", err, errNoReadDeadline)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"context"
+	"io"
+)
+
+// WithContext makes the Reader or Writer stop waiting for
+// its limiter once ctx is done, returning ctx.Err() from
+// the read or write, so that a server can shut down
+// gracefully even while its streams are throttled, or
+// limited to a rate of 0. It does not interrupt reads
+// from or writes to the underlying stream; to do so,
+// close it, or set a deadline on it.
+//
+// Once ctx is done, every read or write which
+// would wait returns ctx.Err().
+func WithContext(ctx context.Context) Option {
+	return func(s *stream) { s.ctx = ctx }
+}
+
+// context returns the context with which s waits
+// for its limiter, unless the wait is bounded.
+func (s *stream) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// NewLimitReaderContext is like NewLimitReader, except
+// that reads stop waiting and return ctx.Err() once ctx
+// is done (see WithContext).
+func NewLimitReaderContext(ctx context.Context, r io.Reader, bps uint64, opts ...LimitOption) *Reader {
+	return NewReader(r, WithLimiter(NewPacer(bps, opts...)), WithContext(ctx))
+}
+
+// NewLimitWriterContext is like NewLimitWriter, except
+// that writes stop waiting and return ctx.Err() once ctx
+// is done (see WithContext).
+func NewLimitWriterContext(ctx context.Context, w io.Writer, bps uint64, opts ...LimitOption) *Writer {
+	return NewWriter(w, WithLimiter(NewPacer(bps, opts...)), WithContext(ctx))
+}
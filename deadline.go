@@ -0,0 +1,137 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// An InfeasibleError is returned when a transfer cannot
+// complete before its deadline at the rate to which it
+// is limited.
+type InfeasibleError struct {
+	// Remaining is the number of units left to transfer,
+	// and Rate is the maximum rate at which they may be
+	// transferred, in units per second.
+	Remaining uint64
+	Rate      uint64
+	// Needed is the least time in which the remaining
+	// units could be transferred, and Left is the time
+	// left until the deadline.
+	Needed, Left time.Duration
+}
+
+func (e *InfeasibleError) Error() string {
+	return fmt.Sprintf("rate: cannot transfer %d units at %d per second by deadline (need %v, have %v)",
+		e.Remaining, e.Rate, e.Needed, e.Left)
+}
+
+// CheckDeadline returns an *InfeasibleError if remaining
+// units cannot be transferred at bps units per second before
+// ctx's deadline, and nil otherwise, including if ctx has
+// no deadline. Applications can use it to fail fast rather
+// than spend bandwidth on a transfer which is doomed to
+// miss its deadline.
+func CheckDeadline(ctx context.Context, remaining, bps uint64) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil
+	}
+	return checkDeadline(deadline, time.Now(), remaining, bps)
+}
+
+func checkDeadline(deadline, now time.Time, remaining, bps uint64) error {
+	if remaining == 0 || bps == Inf {
+		return nil
+	}
+	needed := time.Duration(math.MaxInt64)
+	if bps > 0 {
+		if secs := float64(remaining) / float64(bps); secs < float64(math.MaxInt64/time.Second) {
+			needed = time.Duration(secs * float64(time.Second))
+		}
+	}
+	if left := deadline.Sub(now); needed > left {
+		return &InfeasibleError{Remaining: remaining, Rate: bps, Needed: needed, Left: left}
+	}
+	return nil
+}
+
+// WithDeadline makes the Reader or Writer fail fast with an
+// *InfeasibleError if, given that size bytes are to be
+// transferred in total, the bytes which remain cannot be
+// transferred by deadline at the rate to which it is limited.
+// The check is made before each transfer, so a transfer which
+// slows down (for example, because it shares a Pacer with
+// others) fails as soon as it can no longer finish in time.
+//
+// The check only applies when the Reader or Writer is limited
+// by a *Pacer, and it allows for any budget which the Pacer
+// may grant in excess of its rate, such as an initial burst,
+// so it never fails a transfer which could finish in time.
+// To honor a context's deadline, pass ctx.Deadline().
+func WithDeadline(deadline time.Time, size uint64) Option {
+	return func(s *stream) {
+		s.deadline = deadline
+		s.size = size
+	}
+}
+
+// bound returns the highest rate at which p may allow units
+// to be consumed in the long run, and the number of units it
+// may allow in excess of that rate. If no bound is known,
+// bps is Inf.
+func (p *Pacer) bound() (bps, extra uint64) {
+	if !p.valid || p.idle == IdleUnlimited && !p.strict && !p.catchUp && p.bucket == 0 {
+		return Inf, 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.bps == Inf {
+		return Inf, 0
+	}
+	bps, extra = p.bps, p.burst
+	switch {
+	case p.bucket > 0:
+		// The tokens in the bucket, which it
+		// may still hold in full.
+		extra += uint64(p.bucket)
+	case p.strict:
+	case p.catchUp:
+		if p.maxBPS > bps {
+			bps = p.maxBPS
+		}
+		extra += uint64(p.left)
+	default:
+		// Whatever is left of this quantum, plus the
+		// next quantum, which may begin immediately.
+		extra += uint64(p.left) + uint64(p.bpq)
+		if p.idle == IdleAccumulate {
+			extra += p.maxCredit
+		}
+	}
+	return bps, extra
+}
+
+// feasible returns an *InfeasibleError if s has a deadline
+// which it provably cannot meet.
+func (s *stream) feasible() error {
+	p, ok := s.l.(*Pacer)
+	if s.deadline.IsZero() || !ok || s.n >= s.size {
+		return nil
+	}
+	bps, extra := p.bound()
+	remaining := s.size - s.n
+	if bps == Inf || remaining <= extra {
+		return nil
+	}
+	err := checkDeadline(s.deadline, p.clock.Now(), remaining-extra, bps)
+	if err != nil {
+		err.(*InfeasibleError).Remaining = remaining
+	}
+	return err
+}
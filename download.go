@@ -0,0 +1,173 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const defaultPartSize = 8 << 20
+
+// A Downloader downloads files over HTTP in parallel ranges,
+// limiting the aggregate rate of all of the ranges, and
+// monitoring their progress as that of a single download:
+//
+//	m := rate.NewMonitor(rate.WithFunc(status.Update))
+//	d := &rate.Downloader{Limiter: rate.NewPacer(10 << 20), Monitor: m}
+//	_, err := d.Download(ctx, url, f)
+//
+// The fields of a Downloader should not be modified
+// while it is in use.
+type Downloader struct {
+	// Client is the client used to make requests. If
+	// it is nil, http.DefaultClient is used.
+	Client *http.Client
+	// PartSize is the size of each range; the default
+	// is 8MB. Concurrency is the number of ranges
+	// downloaded at once; the default is 4.
+	PartSize    int64
+	Concurrency int
+	// Retries is the number of times the download of a
+	// range is retried, resuming where it left off.
+	Retries int
+
+	// Limiter, if non-nil, limits the aggregate rate of
+	// the download, and Monitor, if non-nil, counts its
+	// bytes.
+	Limiter Limiter
+	Monitor *Monitor
+
+	// OnSize, if non-nil, is called with the size of
+	// the file once it is known, before any of it is
+	// downloaded, so that progress can be reported
+	// (see StatusLine.Expected).
+	OnSize func(size int64)
+}
+
+// Download downloads the file at url into w, and returns
+// its size. If the server does not report the size of the
+// file, or does not support range requests, the file is
+// downloaded in a single request.
+func (d *Downloader) Download(ctx context.Context, url string, w io.WriterAt) (int64, error) {
+	size, ranges, err := d.head(ctx, url)
+	if err != nil {
+		return 0, err
+	}
+	if d.OnSize != nil && size >= 0 {
+		d.OnSize(size)
+	}
+	opts := []Option{WithLimiter(d.Limiter), WithMonitor(d.Monitor)}
+	if size < 0 || !ranges {
+		body, err := d.get(ctx, url, 0, -1)
+		if err != nil {
+			return 0, err
+		}
+		defer body.Close()
+		return io.Copy(&offsetWriter{w: w}, NewReader(body, opts...))
+	}
+
+	partSize := d.PartSize
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+	n := d.Concurrency
+	if n <= 0 {
+		n = 4
+	}
+	err = Parallel(ctx, size, partSize, n, func(ctx context.Context, p Part) error {
+		return d.part(ctx, url, p, w)
+	}, opts...)
+	return size, err
+}
+
+// part downloads a single part, retrying up to d.Retries
+// times from the point at which the last attempt failed.
+func (d *Downloader) part(ctx context.Context, url string, p Part, w io.WriterAt) (err error) {
+	off, end := p.Offset, p.Offset+p.Size
+	for attempt := 0; attempt <= d.Retries && off < end; attempt++ {
+		var body io.ReadCloser
+		body, err = d.get(ctx, url, off, end-off)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			continue
+		}
+		ow := &offsetWriter{w: w, off: off}
+		_, err = io.Copy(ow, p.Reader(io.LimitReader(body, end-off)))
+		body.Close()
+		off = ow.off
+		if err == nil && off < end {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (d *Downloader) client() *http.Client {
+	if d.Client == nil {
+		return http.DefaultClient
+	}
+	return d.Client
+}
+
+// head returns the size of the file at url, or -1 if it
+// is not known, and whether the server supports ranges.
+func (d *Downloader) head(ctx context.Context, url string) (size int64, ranges bool, err error) {
+	req, err := http.NewRequest("HEAD", url, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	resp, err := d.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, false, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, false, fmt.Errorf("rate: HEAD %s: unexpected status %s", url, resp.Status)
+	}
+	return resp.ContentLength, resp.Header.Get("Accept-Ranges") == "bytes", nil
+}
+
+// get requests n bytes of the file at url starting at
+// off, or the whole file if n < 0.
+func (d *Downloader) get(ctx context.Context, url string, off, n int64) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	want := http.StatusOK
+	if n >= 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+		want = http.StatusPartialContent
+	}
+	resp, err := d.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != want {
+		resp.Body.Close()
+		return nil, fmt.Errorf("rate: GET %s: unexpected status %s", url, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// An offsetWriter writes to an io.WriterAt sequentially.
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (o *offsetWriter) Write(p []byte) (n int, err error) {
+	n, err = o.w.WriteAt(p, o.off)
+	o.off += int64(n)
+	return
+}
@@ -0,0 +1,83 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// A DropWriter wraps an io.Writer to which messages (such as
+// datagrams, or video frames) are written, and drops messages
+// which exceed its limit rather than delaying them, for real-
+// time pipelines which prefer loss to latency. Each call to
+// Write is a single message, which is written whole or not at
+// all; a message larger than the limiter allows at once is
+// always dropped.
+//
+// The bytes of dropped messages are counted as wasted by the
+// DropWriter's Monitor, so that the drop rate is reported in
+// the WasteRate field of each Rate:
+//
+//	m := rate.NewMonitor(rate.WithFunc(report))
+//	w := rate.NewDropWriter(udpConn, rate.WithLimit(1<<20), rate.WithMonitor(m))
+type DropWriter struct {
+	dropped uint64 // accessed atomically
+	w       io.Writer
+	stream
+}
+
+// An Allower is a limiter which reports whether units are
+// available without waiting for them, as *Pacer and the
+// Limiter of golang.org/x/time/rate do.
+type Allower interface {
+	AllowN(now time.Time, n int) bool
+}
+
+// NewDropWriter returns a new DropWriter which writes to w.
+// It accepts the same options as NewWriter. A Limiter given
+// with WithLimiter should be an Allower, which is asked
+// whether the bytes of each message are available; any other
+// Limiter is asked for them with a context which is already
+// done, and so must fail rather than wait when they are not,
+// as Limiters which Compose returns from *Pacers do.
+func NewDropWriter(w io.Writer, opts ...Option) *DropWriter {
+	return &DropWriter{w: w, stream: newStream(opts)}
+}
+
+// Write writes p as a single message, or drops it if it
+// exceeds d's limit. Dropping a message is not an error;
+// Write reports that all of p was written.
+func (d *DropWriter) Write(p []byte) (n int, err error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	if d.l != nil && len(p) > 0 && !d.allow(len(p)) {
+		atomic.AddUint64(&d.dropped, 1)
+		d.AddWasted(uint64(len(p)))
+		return len(p), nil
+	}
+	n, err = d.w.Write(p)
+	d.failed(err)
+	if d.m != nil {
+		d.m.Add(uint64(n))
+	}
+	return n, err
+}
+
+// allow reports whether n bytes are available
+// from d's limiter, and if so, consumes them.
+func (d *DropWriter) allow(n int) bool {
+	if a, ok := d.l.(Allower); ok {
+		return a.AllowN(time.Now(), n)
+	}
+	return d.l.WaitN(doneCtx, n) == nil
+}
+
+// Dropped returns the number of messages dropped so far.
+func (d *DropWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&d.dropped)
+}
@@ -0,0 +1,68 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+var errSockoptUnsupported = errors.New("rate: socket option not supported on this platform")
+
+// SetDSCP sets the Differentiated Services Code Point with which
+// the packets of c's underlying connection are marked, so that
+// shapers in the network can treat them according to the same
+// policy as c's limits; for example, a bulk transfer can be
+// marked as background traffic when it is throttled:
+//
+//	c := rate.NewLimitConn(raw, 0, 1<<20)
+//	if err := c.SetDSCP(8); err != nil { // CS1, the lower-effort class
+//		log.Print(err)
+//	}
+//
+// dscp must be between 0 and 63. It is written to the upper six
+// bits of the IPv4 TOS or IPv6 traffic class field; the lower
+// two bits, used for ECN, are left to the operating system.
+// SetDSCP fails if the underlying connection does not expose
+// its socket (see SyscallConn), or on platforms which do not
+// support setting the field.
+func (c *Conn) SetDSCP(dscp int) error {
+	if dscp < 0 || dscp > 63 {
+		return fmt.Errorf("rate: invalid DSCP %d", dscp)
+	}
+	v6 := false
+	switch a := c.LocalAddr().(type) {
+	case *net.TCPAddr:
+		v6 = a.IP.To4() == nil
+	case *net.UDPAddr:
+		v6 = a.IP.To4() == nil
+	}
+	return c.control(func(fd uintptr) error { return setTOS(fd, v6, dscp<<2) })
+}
+
+// SetPriority sets the priority (SO_PRIORITY) of the packets
+// sent on c's underlying connection, which selects the queue
+// they are placed in by queueing disciplines such as prio
+// and mqprio. It is only supported on Linux, and fails on
+// other platforms, or if the underlying connection does not
+// expose its socket (see SyscallConn).
+func (c *Conn) SetPriority(prio int) error {
+	return c.control(func(fd uintptr) error { return setPriority(fd, prio) })
+}
+
+// control calls f with the file descriptor
+// of c's underlying socket.
+func (c *Conn) control(f func(fd uintptr) error) error {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ferr error
+	if err := rc.Control(func(fd uintptr) { ferr = f(fd) }); err != nil {
+		return err
+	}
+	return ferr
+}
@@ -0,0 +1,54 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import "context"
+
+// A DualLimiter limits small operations, such as heartbeats,
+// acknowledgements, and other control messages, separately
+// from bulk transfers, so that the former are never delayed
+// behind the latter, while the two combined still respect
+// a total limit. It reserves part of the total for small
+// operations; the reserved budget is not available to bulk
+// transfers even while it goes unused.
+//
+// When a DualLimiter limits a Reader or Writer, each read
+// or write is an operation, and large writes are waited for
+// in chunks of at most 32KB, as for any Limiter other than
+// *Pacer.
+type DualLimiter struct {
+	small, bulk *Pacer
+	threshold   int
+}
+
+var _ Limiter = (*DualLimiter)(nil)
+
+// NewDualLimiter returns a new DualLimiter which allows a
+// total of bps units per second, of which small are reserved
+// for operations of at most threshold units. If small >= bps,
+// bulk transfers are blocked forever.
+func NewDualLimiter(bps, small uint64, threshold int, opts ...LimitOption) *DualLimiter {
+	bulk := uint64(0)
+	switch {
+	case bps == Inf:
+		bulk = Inf
+	case bps > small:
+		bulk = bps - small
+	}
+	return &DualLimiter{
+		small:     NewPacer(small, opts...),
+		bulk:      NewPacer(bulk, opts...),
+		threshold: threshold,
+	}
+}
+
+// WaitN waits for n units from the small budget if n is
+// at most the threshold, and from the bulk budget otherwise.
+func (d *DualLimiter) WaitN(ctx context.Context, n int) error {
+	if n <= d.threshold {
+		return d.small.WaitN(ctx, n)
+	}
+	return d.bulk.WaitN(ctx, n)
+}
@@ -0,0 +1,81 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"sync"
+	"time"
+)
+
+// A DutyCycle monitors how busy something (such as a worker)
+// is. Rather than counting events, callers mark intervals
+// during which it is busy, and each period, the Monitor
+// reports the fraction of the period which was busy as the
+// Rate, and the total busy time, in nanoseconds, as the Total.
+//
+// Intervals may overlap, in which case each counts separately;
+// for example, if a DutyCycle is shared by a pool of four
+// workers which are all busy, it reports a rate of 4. The
+// rate is then the average number of busy workers.
+type DutyCycle struct {
+	*Monitor
+
+	mu     sync.Mutex
+	active int
+	last   time.Time
+}
+
+// NewDutyCycle creates a new DutyCycle. The options are
+// the same as those accepted by NewMonitor, except that
+// WithRateUnit has no effect.
+func NewDutyCycle(opts ...MonitorOption) *DutyCycle {
+	d := &DutyCycle{Monitor: newMonitor(opts)}
+	d.scale = 1 / float64(time.Second)
+	d.before = func(t time.Time) {
+		d.mu.Lock()
+		d.flush(t)
+		d.mu.Unlock()
+	}
+	d.running = 1
+	go d.monitor()
+	return d
+}
+
+// flush counts the busy time since the last flush.
+// d.mu must be held.
+func (d *DutyCycle) flush(t time.Time) {
+	if d.active > 0 && t.After(d.last) {
+		d.Add(uint64(d.active) * uint64(t.Sub(d.last)))
+	}
+	d.last = t
+}
+
+// Start marks the beginning of a busy interval. Busy time
+// is counted as it elapses, so intervals which span several
+// periods are reported accurately.
+func (d *DutyCycle) Start() {
+	d.mu.Lock()
+	d.flush(time.Now())
+	d.active++
+	d.mu.Unlock()
+}
+
+// Stop marks the end of a busy interval started with Start.
+func (d *DutyCycle) Stop() {
+	d.mu.Lock()
+	d.flush(time.Now())
+	if d.active > 0 {
+		d.active--
+	}
+	d.mu.Unlock()
+}
+
+// AddDuration counts a busy interval of length dur which
+// has already ended.
+func (d *DutyCycle) AddDuration(dur time.Duration) {
+	if dur > 0 {
+		d.Add(uint64(dur))
+	}
+}
@@ -0,0 +1,365 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Since the units consumed through a Pacer are abstract, it
+// can limit the rate of events which are not bytes, such as
+// API calls or jobs, as well. Besides waiting for units with
+// WaitN, callers can ask whether units are available now
+// with AllowN, or reserve units ahead of time with ReserveN,
+// as with the Limiter of golang.org/x/time/rate.
+
+// Wait is shorthand for WaitN(ctx, 1).
+func (p *Pacer) Wait(ctx context.Context) error {
+	return p.WaitN(ctx, 1)
+}
+
+// Allow is shorthand for AllowN(time.Now(), 1).
+func (p *Pacer) Allow() bool {
+	return p.AllowN(time.Now(), 1)
+}
+
+// AllowN reports whether n units may be consumed at time now
+// (usually time.Now()) without waiting, and if so, consumes
+// them. Callers which would otherwise drop or reject an event
+// use AllowN instead of WaitN. AllowN does not consume units
+// while other goroutines are waiting for p's budget, so that
+// they are not starved.
+func (p *Pacer) AllowN(now time.Time, n int) bool {
+	if n <= 0 || !p.valid {
+		return true
+	}
+	p.mu.Lock()
+	ok := p.reserveAll(now, n)
+	p.mu.Unlock()
+	if !ok {
+		return false
+	}
+	if p.parent != nil && p.parent.WaitN(doneCtx, n) != nil {
+		p.refundOwn(n)
+		return false
+	}
+	return true
+}
+
+// reserveAll consumes n units at time now if they are
+// all available, and reports whether it did. p.mu must
+// be held.
+func (p *Pacer) reserveAll(now time.Time, n int) bool {
+	switch {
+	case p.busy || p.bps == 0:
+		return false
+	case p.bps == Inf:
+		return true
+	}
+	for got := 0; got < n; {
+		k, _ := p.reserve(now, n-got)
+		if k == 0 {
+			p.refundLocked(got)
+			return false
+		}
+		got += k
+	}
+	return true
+}
+
+// A Reservation holds units reserved by ReserveN, which may
+// be consumed once its delay has passed. A Reservation is not
+// safe for concurrent use.
+type Reservation struct {
+	p  *Pacer
+	ok bool
+	n  int
+	at time.Time // when the units may be consumed
+
+	end    time.Time // p.t0 once the units were reserved
+	quanta int       // the number of quanta waited for
+	last   int       // the units reserved in the last of them
+}
+
+// Reserve is shorthand for ReserveN(time.Now(), 1).
+func (p *Pacer) Reserve() *Reservation {
+	return p.ReserveN(time.Now(), 1)
+}
+
+// ReserveN reserves n units at time now (usually time.Now()),
+// and returns a Reservation which tells the caller how long
+// to wait before consuming them. Unlike WaitN, ReserveN does
+// not block, so that callers can decide for themselves
+// whether to wait, or to give up and Cancel the Reservation:
+//
+//	r := p.ReserveN(time.Now(), 1)
+//	if !r.OK() || r.Delay() > maxDelay {
+//		r.Cancel()
+//		return errBusy
+//	}
+//	time.Sleep(r.Delay())
+//	act()
+//
+// The units count against p's limit at once, so that later
+// callers wait for the reserved units as well. If p's rate
+// is 0, the units can never be consumed, and the Reservation
+// is not OK. Units are not reserved from p's parent, if any.
+func (p *Pacer) ReserveN(now time.Time, n int) *Reservation {
+	r := &Reservation{p: p, ok: true, n: n, at: now}
+	if n <= 0 || !p.valid {
+		return r
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch p.bps {
+	case 0:
+		r.ok = false
+		return r
+	case Inf:
+		return r
+	}
+	// Consume the units which are available now,
+	// and then those which will become available
+	// later, so that p's state reflects them when
+	// they are due.
+	got := 0
+	for got < n {
+		k, _ := p.reserve(now, n-got)
+		if k == 0 {
+			break
+		}
+		got += k
+		r.last = k
+	}
+	if got < n {
+		p.reserveAhead(r, n-got)
+	}
+	r.end = p.t0
+	if r.at.After(now) {
+		// The rest of the budget available at r.at
+		// is not available before it; rather than
+		// let later callers consume it early, it is
+		// forfeited.
+		p.left = 0
+	}
+	return r
+}
+
+// reserveAhead consumes n units from the quanta after the
+// current one, which has none left, and records in r when
+// the last of them is due. It computes the number of quanta
+// needed rather than stepping through them, so that large
+// reservations do not hold p.mu for long. p.mu must be held.
+func (p *Pacer) reserveAhead(r *Reservation, n int) {
+	at := p.t0
+	switch {
+	case p.bucket > 0:
+		// The bucket is empty, and refilled up to p.t0,
+		// so the units are due once n more are refilled.
+		perUnit := float64(time.Second) / float64(p.bps)
+		chunk := p.bpq
+		if chunk > p.bucket {
+			chunk = p.bucket
+		}
+		r.at = at.Add(time.Duration(float64(n) * perUnit))
+		r.quanta += (n + chunk - 1) / chunk
+		r.last = (n-1)%chunk + 1
+		p.t0, p.left = r.at, 0
+	case p.strict:
+		// Each transfer of up to p.bpq units delays
+		// the next by the time taken to send it.
+		full := (n - 1) / p.bpq
+		r.last = n - full*p.bpq
+		r.at = at.Add(time.Duration(full) * (time.Duration(p.bpq) * time.Second / time.Duration(p.bps)))
+		r.quanta += full + 1
+		p.t0 = r.at.Add(time.Duration(r.last) * time.Second / time.Duration(p.bps))
+		p.left = p.bpq - r.last
+	case p.catchUp:
+		// The quantum beginning i quanta after at allows
+		// up to p.maxBPQ units, and no more than bring the
+		// total to allowed(i).
+		allowed := func(i int) uint64 {
+			end := at.Add(time.Duration(i+1) * p.quantum)
+			return uint64(float64(p.bps) * end.Sub(p.start).Seconds())
+		}
+		// after estimates the first quantum by whose end
+		// the total may reach k.
+		after := func(k uint64) int {
+			d := float64(k)/float64(p.bps)*float64(time.Second) - float64(at.Add(p.quantum).Sub(p.start))
+			return int(math.Ceil(d / float64(p.quantum)))
+		}
+		max := uint64(p.maxBPQ)
+		total := p.total
+		target := total + uint64(n)
+
+		// No units are allowed until quantum i0, if the
+		// total is ahead of the average, as after an
+		// initial burst.
+		i0 := search(after(total), func(i int) bool { return allowed(i) >= total })
+		first := total + max
+		if a := allowed(i0); a < first {
+			first = a
+		}
+		// If the rate allows more than p.maxBPQ units
+		// per quantum, the total only ever falls further
+		// behind the average once p.maxBPQ limits it.
+		fast := float64(p.bps)*p.quantum.Seconds() > float64(max)
+		// granted returns the total by the end of the
+		// quantum beginning i quanta after at.
+		granted := func(i int) uint64 {
+			switch {
+			case i < i0:
+				return total
+			case fast:
+				return first + uint64(i-i0)*max
+			}
+			g := total + uint64(i-i0+1)*max
+			if a := allowed(i); a < g {
+				g = a
+			}
+			return g
+		}
+		guess := after(target)
+		if g := i0 + int((uint64(n)+max-1)/max) - 1; g > guess {
+			guess = g
+		}
+		if fast && target > first {
+			if g := i0 + int((target-first+max-1)/max); g > guess {
+				guess = g
+			}
+		}
+		i := search(guess, func(i int) bool { return granted(i) >= target })
+		prev := granted(i - 1)
+		avail := prev + max
+		if a := allowed(i); a < avail {
+			avail = a
+		}
+		r.at = at.Add(time.Duration(i) * p.quantum)
+		r.quanta += i + 1
+		r.last = int(target - prev)
+		p.t0 = r.at.Add(p.quantum)
+		p.left = int(avail - target)
+	default:
+		// Each quantum allows p.bpq units, and unused
+		// budget does not carry over between quanta
+		// which are used.
+		m := (n + p.bpq - 1) / p.bpq
+		r.at = at.Add(time.Duration(m-1) * p.quantum)
+		r.quanta += m
+		r.last = n - (m-1)*p.bpq
+		p.t0 = r.at.Add(p.quantum)
+		p.left = m*p.bpq - n
+	}
+	p.total += uint64(n)
+}
+
+// search returns the least i >= 0 for which f(i) is true,
+// starting from a guess near it. f must be monotonic.
+func search(guess int, f func(i int) bool) int {
+	i := guess
+	if i < 0 {
+		i = 0
+	}
+	for !f(i) {
+		i++
+	}
+	for i > 0 && f(i-1) {
+		i--
+	}
+	return i
+}
+
+// OK reports whether the units can ever be consumed.
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay is shorthand for DelayFrom(time.Now()).
+func (r *Reservation) Delay() time.Duration {
+	return r.DelayFrom(time.Now())
+}
+
+// DelayFrom returns how long after now the caller must
+// wait before consuming the reserved units; 0 means that
+// they may be consumed at once. If the Reservation is
+// not OK, DelayFrom returns the maximum Duration.
+func (r *Reservation) DelayFrom(now time.Time) time.Duration {
+	if !r.ok {
+		return math.MaxInt64
+	}
+	if d := r.at.Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Cancel is shorthand for CancelAt(time.Now()).
+func (r *Reservation) Cancel() {
+	r.CancelAt(time.Now())
+}
+
+// CancelAt indicates that the reserved units will not be
+// consumed, and returns those which were reserved from the
+// budget for times after now to the Pacer, so that other
+// callers may consume them instead. Units which were due
+// by now are not returned, and neither are any units if
+// others have been reserved from p since. Cancelling a
+// Reservation more than once has no further effect.
+func (r *Reservation) CancelAt(now time.Time) {
+	if !r.ok || r.n <= 0 || !now.Before(r.at) {
+		return
+	}
+	r.p.unreserve(r, now)
+	r.n = 0
+}
+
+// unreserve returns the units of r which were reserved
+// from the budget for times after now to p.
+func (p *Pacer) unreserve(r *Reservation, now time.Time) {
+	if !p.valid {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.bps == 0 || p.bps == Inf || !p.t0.Equal(r.end) {
+		// Units reserved since r are due at times
+		// which assume that r's units are consumed.
+		return
+	}
+	var k int
+	if p.bucket > 0 || p.strict {
+		// p.t0 is the time at which the last of r's
+		// units are due, so the units due after now
+		// are those for the time between them.
+		perUnit := float64(time.Second) / float64(p.bps)
+		k = int(float64(p.t0.Sub(now)) / perUnit)
+		if k > r.n {
+			k = r.n
+		}
+		p.t0 = p.t0.Add(-time.Duration(float64(k) * perUnit))
+	} else {
+		// p.t0 is the end of the last quantum from which
+		// r's units were reserved; return the budget for
+		// those of r's quanta which begin after now.
+		m := int((r.at.Sub(now) + p.quantum - 1) / p.quantum)
+		if m > r.quanta {
+			m = r.quanta
+		}
+		if m == 0 {
+			return
+		}
+		k = r.last + (m-1)*p.bpq
+		if k > r.n {
+			k = r.n
+		}
+		p.t0 = p.t0.Add(-time.Duration(m) * p.quantum)
+	}
+	if uint64(k) > p.total {
+		k = int(p.total)
+	}
+	p.total -= uint64(k)
+}
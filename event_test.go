@@ -0,0 +1,92 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"testing"
+	"time"
+)
+
+// TestCancelReservation tests that cancelling a large
+// Reservation does not let its units be consumed in a
+// burst above the rate.
+func TestCancelReservation(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		opts []LimitOption
+	}{
+		{"quantum", nil},
+		{"strict", []LimitOption{WithStrictPacing()}},
+		{"bucket", []LimitOption{WithBurst(100)}},
+	} {
+		p := NewPacer(1000, c.opts...)
+		now := time.Now()
+		for p.AllowN(now, 1) {
+		}
+		r := p.ReserveN(now, 10000)
+		if d := r.DelayFrom(now); d < 9*time.Second {
+			t.Fatalf("%s: got delay %v; want at least 9s", c.name, d)
+		}
+		r.CancelAt(now)
+
+		// Over the next second, no more than a second's worth
+		// of units, and one quantum or bucket besides, may be
+		// consumed.
+		var n int
+		for i := 0; i <= 1000; i++ {
+			at := now.Add(time.Duration(i) * time.Millisecond)
+			for p.AllowN(at, 1) {
+				n++
+			}
+		}
+		if n > 1100 {
+			t.Errorf("%s: consumed %v units in 1s after cancelling; want at most 1100", c.name, n)
+		}
+		if n < 900 {
+			t.Errorf("%s: consumed %v units in 1s after cancelling; want at least 900", c.name, n)
+		}
+	}
+}
+
+// TestCancelReservationLater tests that cancelling a
+// Reservation after another does not return its units.
+func TestCancelReservationLater(t *testing.T) {
+	p := NewPacer(1000)
+	now := time.Now()
+	for p.AllowN(now, 1) {
+	}
+	r := p.ReserveN(now, 1000)
+	p.ReserveN(now, 1000)
+	r.CancelAt(now)
+	if d := p.ReserveN(now, 1).DelayFrom(now); d < 2*time.Second {
+		t.Errorf("got delay %v; want at least 2s", d)
+	}
+}
+
+// TestReserveLarge tests that reserving many quanta's
+// worth of units delays them by the time they take at
+// p's rate.
+func TestReserveLarge(t *testing.T) {
+	const n = 1e9
+	for _, c := range []struct {
+		name string
+		opts []LimitOption
+	}{
+		{"quantum", nil},
+		{"strict", []LimitOption{WithStrictPacing()}},
+		{"bucket", []LimitOption{WithBurst(100)}},
+		{"catchup", []LimitOption{WithCatchUp(2000)}},
+	} {
+		p := NewPacer(1000, c.opts...)
+		now := time.Now()
+		d := p.ReserveN(now, n).DelayFrom(now)
+		if want := n / 1000 * time.Second; d < want*99/100 || d > want*101/100 {
+			t.Errorf("%s: got delay %v; want %v", c.name, d, want)
+		}
+		if d2 := p.ReserveN(now, 1000).DelayFrom(now); d2 < d+time.Second*99/100 {
+			t.Errorf("%s: got delay %v after reservation of %v; want at least %v", c.name, d2, d, d+time.Second)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import "os/exec"
+
+// CmdOptions configures the streams wrapped by WrapCmd.
+type CmdOptions struct {
+	// All configures all of the streams. A Limiter or
+	// Monitor given here is shared by them, and so limits
+	// or counts them in aggregate.
+	All []Option
+	// Stdin, Stdout, and Stderr configure the individual
+	// streams, after All. If both All and one of these
+	// give a Monitor (or other Counter), the stream's
+	// bytes are counted by both.
+	Stdin, Stdout, Stderr []Option
+}
+
+// CmdStreams holds the streams of a command wrapped by
+// WrapCmd. A stream is nil if the corresponding field of
+// the command was nil.
+type CmdStreams struct {
+	Stdin          *Reader
+	Stdout, Stderr *Writer
+}
+
+// WrapCmd wraps the standard streams of cmd (cmd.Stdin,
+// cmd.Stdout, and cmd.Stderr) which are set in Readers and
+// Writers, so that the rate at which a child process consumes
+// and produces data can be limited and monitored:
+//
+//	m := rate.NewMonitor(rate.WithFunc(report))
+//	cmd := exec.Command("pg_dump", "db")
+//	cmd.Stdout, cmd.Stderr = f, os.Stderr
+//	rate.WrapCmd(cmd, rate.CmdOptions{
+//		All:    []rate.Option{rate.WithMonitor(m)},
+//		Stdout: []rate.Option{rate.WithLimit(10 << 20)},
+//	})
+//	err := cmd.Run()
+//
+// WrapCmd must be called before the command is started.
+// Since the wrapped streams are not *os.Files, the exec
+// package copies them to and from the child process through
+// pipes; a child which writes faster than its limit blocks
+// once the pipe is full. Limiting Stdin limits the rate at
+// which the child is given input.
+func WrapCmd(cmd *exec.Cmd, opts CmdOptions) CmdStreams {
+	var s CmdStreams
+	stdout := cmd.Stdout
+	if cmd.Stdin != nil {
+		s.Stdin = NewReader(cmd.Stdin, cmdOpts(opts.All, opts.Stdin)...)
+		cmd.Stdin = s.Stdin
+	}
+	if cmd.Stdout != nil {
+		s.Stdout = NewWriter(cmd.Stdout, cmdOpts(opts.All, opts.Stdout)...)
+		cmd.Stdout = s.Stdout
+	}
+	if cmd.Stderr != nil {
+		// If Stdout and Stderr are the same, exec
+		// serializes writes to them; keep them the
+		// same so that it still does.
+		if s.Stdout != nil && interfaceEqual(cmd.Stderr, stdout) {
+			s.Stderr = s.Stdout
+		} else {
+			s.Stderr = NewWriter(cmd.Stderr, cmdOpts(opts.All, opts.Stderr)...)
+		}
+		cmd.Stderr = s.Stderr
+	}
+	return s
+}
+
+// interfaceEqual is like a == b, but returns
+// false rather than panicking if they hold the
+// same uncomparable type.
+func interfaceEqual(a, b interface{}) (eq bool) {
+	defer func() { recover() }()
+	return a == b
+}
+
+// cmdOpts returns the options for a stream configured by
+// all and then own, counting its bytes with the Counters
+// given by both, if they both give one.
+func cmdOpts(all, own []Option) []Option {
+	opts := append(append([]Option(nil), all...), own...)
+	a, o := newStream(all).m, newStream(own).m
+	if a != nil && o != nil && a != o {
+		opts = append(opts, WithCounter(counters{o, a}))
+	}
+	return opts
+}
+
+// counters counts events with several Counters. It
+// reports the total and rate of the first.
+type counters []Counter
+
+func (c counters) Add(n uint64) {
+	for _, m := range c {
+		m.Add(n)
+	}
+}
+
+func (c counters) Total() uint64 {
+	return (&stream{m: c[0]}).Total()
+}
+
+func (c counters) CurrentRate() float64 {
+	return (&stream{m: c[0]}).CurrentRate()
+}
+
+func (c counters) Waste(n uint64) {
+	for _, m := range c {
+		(&stream{m: m}).Waste(n)
+	}
+}
+
+func (c counters) AddWasted(n uint64) {
+	for _, m := range c {
+		(&stream{m: m}).AddWasted(n)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"fmt"
+	"math"
+)
+
+// FormatBytes formats n bytes using binary (IEC) prefixes,
+// which are powers of 1024, such as "3.4 MiB". Quantities
+// of less than 1KiB are formatted as whole bytes. Rates
+// can be formatted by appending "/s":
+//
+//	fmt.Println(rate.FormatBytes(r.Rate) + "/s")
+func FormatBytes(n float64) string {
+	return formatUnits(n, 1024, "KMGTPE", "iB")
+}
+
+// FormatBytesSI is like FormatBytes, but uses decimal (SI)
+// prefixes, which are powers of 1000, such as "3.6 MB".
+func FormatBytesSI(n float64) string {
+	return formatUnits(n, 1000, "kMGTPE", "B")
+}
+
+// formatUnits formats n using the given prefixes, each of
+// which is base times the last, followed by suffix.
+func formatUnits(n, base float64, prefixes, suffix string) string {
+	if math.Abs(n) < base {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	i := -1
+	for math.Abs(n) >= base && i < len(prefixes)-1 {
+		n /= base
+		i++
+	}
+	return fmt.Sprintf("%.1f %c%s", n, prefixes[i], suffix)
+}
+
+// String formats r as a number of bytes and a rate in
+// bytes per second, using FormatBytes, such as "12.0 MiB
+// at 3.4 MiB/s". A Rate reported by a Monitor which counts
+// other events, or which was given WithRateUnit, should be
+// formatted by the caller.
+func (r Rate) String() string {
+	return FormatBytes(float64(r.Total)) + " at " + FormatBytes(r.Rate) + "/s"
+}
@@ -0,0 +1,3 @@
+module github.com/joshlf/rate
+
+go 1.17
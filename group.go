@@ -0,0 +1,129 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import "sync"
+
+// A LimiterGroup splits a total limit among its members in
+// proportion to their weights, so that, for example, a
+// high-priority transfer can be given three times the share
+// of a background sync without fixing either's limit:
+//
+//	g := rate.NewLimiterGroup(10 << 20)
+//	hi, lo := g.Add(3), g.Add(1)
+//	defer hi.Close()
+//	defer lo.Close()
+//	go io.Copy(dst1, hi.Reader(src1)) // 7.5MB/s
+//	go io.Copy(dst2, lo.Reader(src2)) // 2.5MB/s
+//
+// The shares are recomputed whenever a member is added,
+// closed, or reweighted. A member's share is reserved for
+// it even while it is idle; close members which are done.
+type LimiterGroup struct {
+	opts []LimitOption
+
+	mu      sync.Mutex
+	bps     uint64
+	members map[*GroupMember]struct{}
+}
+
+// A GroupMember is a Pacer whose rate is its share of
+// the limit of a LimiterGroup.
+type GroupMember struct {
+	*Pacer
+	g      *LimiterGroup
+	weight float64 // guarded by g.mu
+}
+
+// NewLimiterGroup returns a new LimiterGroup which splits
+// a total of bps units per second among its members. opts
+// configure the Pacers of its members.
+func NewLimiterGroup(bps uint64, opts ...LimitOption) *LimiterGroup {
+	return &LimiterGroup{
+		opts:    opts,
+		bps:     bps,
+		members: make(map[*GroupMember]struct{}),
+	}
+}
+
+// Add adds a member with the given weight to g. If weight
+// is not positive, a weight of 1 is used.
+func (g *LimiterGroup) Add(weight float64) *GroupMember {
+	if weight <= 0 {
+		weight = 1
+	}
+	m := &GroupMember{Pacer: NewPacer(0, g.opts...), g: g, weight: weight}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.members[m] = struct{}{}
+	g.rebalance()
+	return m
+}
+
+// SetRate changes the total limit of g
+// to bps units per second.
+func (g *LimiterGroup) SetRate(bps uint64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.bps = bps
+	g.rebalance()
+}
+
+// Len returns the number of members of g.
+func (g *LimiterGroup) Len() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.members)
+}
+
+// rebalance sets the rate of each member of g
+// to its share of the total. g.mu must be held.
+func (g *LimiterGroup) rebalance() {
+	var sum float64
+	for m := range g.members {
+		sum += m.weight
+	}
+	for m := range g.members {
+		bps := g.bps
+		if bps != Inf {
+			bps = uint64(float64(g.bps) * m.weight / sum)
+			if bps == 0 && g.bps > 0 {
+				// Never stop a member altogether
+				// on account of rounding.
+				bps = 1
+			}
+		}
+		m.Pacer.SetRate(bps)
+	}
+}
+
+// SetWeight changes the weight of m, and the shares
+// of the members of its group accordingly. If weight
+// is not positive, a weight of 1 is used.
+func (m *GroupMember) SetWeight(weight float64) {
+	if weight <= 0 {
+		weight = 1
+	}
+	m.g.mu.Lock()
+	defer m.g.mu.Unlock()
+	m.weight = weight
+	if _, ok := m.g.members[m]; ok {
+		m.g.rebalance()
+	}
+}
+
+// Close removes m from its group, so that its share is
+// split among the other members. Streams which m still
+// limits are no longer limited by it.
+func (m *GroupMember) Close() {
+	m.g.mu.Lock()
+	defer m.g.mu.Unlock()
+	if _, ok := m.g.members[m]; !ok {
+		return
+	}
+	delete(m.g.members, m)
+	m.g.rebalance()
+	m.Pacer.SetRate(Inf)
+}
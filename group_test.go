@@ -0,0 +1,49 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import "testing"
+
+// TestLimiterGroupShares tests that the members of a
+// LimiterGroup are given shares of its limit in proportion
+// to their weights as members are added, reweighted, and
+// closed.
+func TestLimiterGroupShares(t *testing.T) {
+	g := NewLimiterGroup(12000)
+	check := func(step string, want map[*GroupMember]uint64) {
+		t.Helper()
+		for m, bps := range want {
+			if got := m.Rate(); got != bps {
+				t.Errorf("%s: member of weight %v has rate %d; want %d", step, m.weight, got, bps)
+			}
+		}
+	}
+
+	a := g.Add(1)
+	check("add a", map[*GroupMember]uint64{a: 12000})
+	b := g.Add(3)
+	check("add b", map[*GroupMember]uint64{a: 3000, b: 9000})
+	c := g.Add(0)
+	check("add c", map[*GroupMember]uint64{a: 2400, b: 7200, c: 2400})
+
+	b.SetWeight(1)
+	check("reweight b", map[*GroupMember]uint64{a: 4000, b: 4000, c: 4000})
+	g.SetRate(6000)
+	check("set rate", map[*GroupMember]uint64{a: 2000, b: 2000, c: 2000})
+
+	a.Close()
+	check("close a", map[*GroupMember]uint64{a: Inf, b: 3000, c: 3000})
+	if n := g.Len(); n != 2 {
+		t.Errorf("got %d members after closing one; want 2", n)
+	}
+	// Closed members neither count nor are limited
+	// when the others change.
+	a.SetWeight(10)
+	c.SetWeight(2)
+	check("reweight c", map[*GroupMember]uint64{a: Inf, b: 2000, c: 4000})
+	a.Close()
+	b.Close()
+	check("close b", map[*GroupMember]uint64{a: Inf, b: Inf, c: 6000})
+}
@@ -0,0 +1,178 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultHandlerIdle is the default time after which
+// a LimitHandler forgets a client.
+const defaultHandlerIdle = 5 * time.Minute
+
+// A LimitHandler is an http.Handler which limits the rates
+// at which each client's request bodies are read and its
+// responses are written. Clients are identified by a key
+// computed from each request, such as the client's address
+// or API key; all of the requests with the same key share
+// the same limits.
+type LimitHandler struct {
+	// IdleTimeout is the time after which a client which
+	// has no requests in progress is forgotten, so that
+	// the memory used by clients which have gone away is
+	// reclaimed. The next request from a forgotten client
+	// starts with a fresh budget. The default is five
+	// minutes. It should not be modified once the
+	// LimitHandler is in use.
+	IdleTimeout time.Duration
+
+	h                 http.Handler
+	key               func(r *http.Request) string
+	readBPS, writeBPS uint64
+	opts              []LimitOption
+
+	mu      sync.Mutex
+	clients map[string]*handlerClient
+	swept   time.Time // when idle clients were last forgotten
+}
+
+type handlerClient struct {
+	read, write *Pacer
+	active      int       // requests in progress
+	last        time.Time // when the last request finished
+}
+
+// NewLimitHandler returns a new LimitHandler which serves
+// requests with h, reading the request bodies of each client
+// at a maximum rate of readBPS bytes per second, and writing
+// its responses at a maximum rate of writeBPS bytes per
+// second. Either may be Inf. key identifies the client which
+// made a request:
+//
+//	byIP := func(r *http.Request) string {
+//		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+//		return host
+//	}
+//	http.ListenAndServe(addr, rate.NewLimitHandler(mux, byIP, 1<<20, 1<<20))
+//
+// opts configure the Pacers of each client. Waits for a
+// client's budget stop once its request's context is done.
+func NewLimitHandler(h http.Handler, key func(r *http.Request) string, readBPS, writeBPS uint64, opts ...LimitOption) *LimitHandler {
+	return &LimitHandler{
+		h:        h,
+		key:      key,
+		readBPS:  readBPS,
+		writeBPS: writeBPS,
+		opts:     opts,
+		clients:  make(map[string]*handlerClient),
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (l *LimitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := l.key(r)
+	c := l.acquire(key)
+	defer l.release(c)
+
+	ctx := WithContext(r.Context())
+	if r.Body != nil && r.Body != http.NoBody {
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.Body = newBody(r.Body, []Option{WithLimiter(c.read), ctx})
+		r = r2
+	}
+	l.h.ServeHTTP(&limitResponseWriter{
+		ResponseWriter: w,
+		w:              NewWriter(w, WithLimiter(c.write), ctx),
+	}, r)
+}
+
+// acquire returns the client with the given key, creating
+// it if necessary, and marks a request as in progress.
+func (l *LimitHandler) acquire(key string) *handlerClient {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	idle := l.IdleTimeout
+	if idle <= 0 {
+		idle = defaultHandlerIdle
+	}
+	if now.Sub(l.swept) > idle {
+		for k, c := range l.clients {
+			if c.active == 0 && now.Sub(c.last) > idle {
+				delete(l.clients, k)
+			}
+		}
+		l.swept = now
+	}
+	c, ok := l.clients[key]
+	if !ok {
+		c = &handlerClient{
+			read:  NewPacer(l.readBPS, l.opts...),
+			write: NewPacer(l.writeBPS, l.opts...),
+		}
+		l.clients[key] = c
+	}
+	c.active++
+	return c
+}
+
+// release marks a request of c as finished.
+func (l *LimitHandler) release(c *handlerClient) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c.active--
+	c.last = time.Now()
+}
+
+// Clients returns the number of clients which l
+// currently remembers.
+func (l *LimitHandler) Clients() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.clients)
+}
+
+// A limitResponseWriter writes a response through a Writer.
+type limitResponseWriter struct {
+	http.ResponseWriter
+	w *Writer
+}
+
+func (w *limitResponseWriter) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+// Unwrap returns the underlying ResponseWriter, so that
+// http.ResponseController can reach its methods. Bytes
+// written to it directly are not limited.
+func (w *limitResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush implements http.Flusher, if the
+// underlying ResponseWriter does.
+func (w *limitResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+var errNoHijack = errors.New("rate: underlying ResponseWriter does not implement http.Hijacker")
+
+// Hijack implements http.Hijacker, if the underlying
+// ResponseWriter does. The connection returned is
+// not limited.
+func (w *limitResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errNoHijack
+}
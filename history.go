@@ -0,0 +1,225 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import "time"
+
+// A Tier describes one resolution at which a Monitor's
+// history is retained: samples are rolled up into buckets
+// of length Step, and buckets are kept for Span.
+type Tier struct {
+	Step, Span time.Duration
+}
+
+// WithHistory makes the Monitor retain its history at each of
+// the given resolutions. For example, to keep 1s resolution
+// for 10 minutes and 1 minute resolution for a day:
+//
+//	rate.WithHistory(
+//		rate.Tier{Step: time.Second, Span: 10 * time.Minute},
+//		rate.Tier{Step: time.Minute, Span: 24 * time.Hour},
+//	)
+//
+// Tiers with a Step shorter than the Monitor's period are
+// of little use, since each period's events are attributed
+// to the bucket in which the period ended.
+func WithHistory(tiers ...Tier) MonitorOption {
+	return func(m *Monitor) {
+		m.tiers = nil
+		for _, t := range tiers {
+			if t.Step <= 0 || t.Span < t.Step {
+				continue
+			}
+			m.tiers = append(m.tiers, &tier{
+				step:    t.Step,
+				buckets: make([]bucket, t.Span/t.Step),
+			})
+		}
+	}
+}
+
+type bucket struct {
+	k     int64 // bucket number; the bucket starts at k*step
+	n     int64
+	d     time.Duration
+	total uint64
+}
+
+type tier struct {
+	step    time.Duration
+	buckets []bucket
+}
+
+func (t *tier) add(r Rate, n int64) {
+	k := r.Time.UnixNano() / int64(t.step)
+	b := &t.buckets[k%int64(len(t.buckets))]
+	if b.k != k || b.d == 0 {
+		*b = bucket{k: k}
+	}
+	b.n += n
+	b.d += r.Interval
+	b.total = r.Total
+}
+
+// span returns the length of time covered by t.
+func (t *tier) span() time.Duration {
+	return t.step * time.Duration(len(t.buckets))
+}
+
+// HistoryRange returns the rates recorded between from and to
+// at resolution res, oldest first. The returned Rates' Time
+// fields are the ends of the corresponding intervals. Only
+// intervals in which at least one period was recorded are
+// returned.
+//
+// HistoryRange uses the coarsest tier configured with
+// WithHistory whose step is no longer than res and which
+// retains history as old as from, falling back to the finest
+// tier retaining history as old as from, or to the coarsest
+// tier if none does. If m was not created with WithHistory,
+// HistoryRange returns nil.
+func (m *Monitor) HistoryRange(from, to time.Time, res time.Duration) []Rate {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.tiers) == 0 || !from.Before(to) {
+		return nil
+	}
+
+	age := time.Since(from)
+	var fine, coarse, longest *tier
+	for _, c := range m.tiers {
+		if longest == nil || c.span() > longest.span() {
+			longest = c
+		}
+		if c.span() < age {
+			continue
+		}
+		if c.step <= res && (coarse == nil || c.step > coarse.step) {
+			coarse = c
+		}
+		if fine == nil || c.step < fine.step {
+			fine = c
+		}
+	}
+	t := coarse
+	if t == nil {
+		t = fine
+	}
+	if t == nil {
+		t = longest
+	}
+	if res < t.step {
+		res = t.step
+	}
+
+	// Gather the buckets in range in chronological order,
+	// merging them into intervals of length res.
+	first := from.UnixNano() / int64(t.step)
+	last := (to.UnixNano() - 1) / int64(t.step)
+	if int(last-first) >= len(t.buckets) {
+		first = last - int64(len(t.buckets)) + 1
+	}
+	var rates []Rate
+	var cur Rate
+	var n, j int64
+	flush := func() {
+		if cur.Interval > 0 {
+			cur.Rate = float64(n) / cur.Interval.Seconds()
+			if m.scale != 0 {
+				cur.Rate *= m.scale
+			}
+			rates = append(rates, cur)
+		}
+		cur, n = Rate{}, 0
+	}
+	for k := first; k <= last; k++ {
+		b := t.buckets[k%int64(len(t.buckets))]
+		if b.k != k || b.d == 0 {
+			continue
+		}
+		end := time.Unix(0, (k+1)*int64(t.step))
+		if i := (end.UnixNano() - 1) / int64(res); i != j {
+			flush()
+			j = i
+		}
+		n += b.n
+		cur.Interval += b.d
+		cur.Total = b.total
+		cur.Time = end
+	}
+	flush()
+	return rates
+}
+
+// Query returns the average rate over the window [from, to),
+// weighted by time, and the number of events which happened
+// in it, according to the history retained by m. Buckets of
+// history which the window covers only partly contribute in
+// proportion to the part covered, as though their events had
+// happened evenly over them. Time for which no history was
+// recorded (for example, before m was created) is excluded
+// from the average; if there is none at all in the window,
+// Query returns 0, 0.
+//
+// Query uses the finest tier configured with WithHistory
+// which retains history as old as from, or the coarsest
+// tier if none does. Events which have happened since the
+// end of the most recent period are not included.
+func (m *Monitor) Query(from, to time.Time) (rate float64, n uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.tiers) == 0 || !from.Before(to) {
+		return 0, 0
+	}
+
+	age := time.Since(from)
+	var t, longest *tier
+	for _, c := range m.tiers {
+		if longest == nil || c.span() > longest.span() {
+			longest = c
+		}
+		if c.span() >= age && (t == nil || c.step < t.step) {
+			t = c
+		}
+	}
+	if t == nil {
+		t = longest
+	}
+
+	first := from.UnixNano() / int64(t.step)
+	last := (to.UnixNano() - 1) / int64(t.step)
+	if int(last-first) >= len(t.buckets) {
+		first = last - int64(len(t.buckets)) + 1
+	}
+	var events, secs float64
+	for k := first; k <= last; k++ {
+		b := t.buckets[k%int64(len(t.buckets))]
+		if b.k != k || b.d == 0 {
+			continue
+		}
+		// The fraction of the bucket in the window.
+		start, end := k*int64(t.step), (k+1)*int64(t.step)
+		if s := from.UnixNano(); s > start {
+			start = s
+		}
+		if e := to.UnixNano(); e < end {
+			end = e
+		}
+		f := float64(end-start) / float64(t.step)
+		events += float64(b.n) * f
+		secs += b.d.Seconds() * f
+	}
+	if secs == 0 {
+		return 0, 0
+	}
+	rate = events / secs
+	if m.scale != 0 {
+		rate *= m.scale
+	}
+	if events > 0 {
+		n = uint64(events + 0.5)
+	}
+	return rate, n
+}
@@ -0,0 +1,160 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A HeavyHitter is a key which consumed a large share of
+// the events counted by a HeavyHitters in a window.
+type HeavyHitter struct {
+	Key string
+	// N is the estimated number of events attributed to
+	// the key in the window. It may overestimate the
+	// true number by at most Err, and never
+	// underestimates it.
+	N, Err uint64
+}
+
+// HeavyHitters tracks which keys (such as client addresses
+// or tenants) account for the most events (such as bytes or
+// requests) in each window of time, so that operators can see
+// at a glance who is consuming bandwidth. It uses a fixed
+// amount of memory regardless of the number of distinct keys,
+// using the Space-Saving algorithm: it tracks a fixed number
+// of keys, and when a key which is not tracked is counted, it
+// replaces the key with the smallest count. Any key which
+// accounts for more than 1/capacity of the events in a
+// window is guaranteed to be reported.
+//
+// It is safe for concurrent use.
+type HeavyHitters struct {
+	k, capacity int
+	window      time.Duration
+
+	mu    sync.Mutex
+	start time.Time // start of the current window
+	cur   hitterHeap
+	keys  map[string]*hitter
+	last  []HeavyHitter // the top keys of the last window
+}
+
+type hitter struct {
+	HeavyHitter
+	i int // index in the heap
+}
+
+// hitterHeap is a min-heap of hitters by count.
+type hitterHeap []*hitter
+
+func (h hitterHeap) Len() int            { return len(h) }
+func (h hitterHeap) Less(i, j int) bool  { return h[i].N < h[j].N }
+func (h hitterHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i]; h[i].i, h[j].i = i, j }
+func (h *hitterHeap) Push(x interface{}) { x.(*hitter).i = len(*h); *h = append(*h, x.(*hitter)) }
+func (h *hitterHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// NewHeavyHitters returns a new HeavyHitters which reports the
+// top k keys of each window. It tracks ten times as many keys,
+// to keep the estimates of the top k accurate. If window
+// is 0, the default window of one minute is used.
+func NewHeavyHitters(k int, window time.Duration) *HeavyHitters {
+	if k < 1 {
+		k = 1
+	}
+	if window <= 0 {
+		window = time.Minute
+	}
+	return &HeavyHitters{
+		k:        k,
+		capacity: 10 * k,
+		window:   window,
+		start:    time.Now(),
+		keys:     make(map[string]*hitter),
+	}
+}
+
+// Add counts n events attributed to key.
+func (h *HeavyHitters) Add(key string, n uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.rotate(time.Now())
+	if e, ok := h.keys[key]; ok {
+		e.N += n
+		heap.Fix(&h.cur, e.i)
+		return
+	}
+	if len(h.cur) < h.capacity {
+		e := &hitter{HeavyHitter: HeavyHitter{Key: key, N: n}}
+		h.keys[key] = e
+		heap.Push(&h.cur, e)
+		return
+	}
+	// Replace the key with the smallest count, on
+	// the assumption that key had as many events.
+	e := h.cur[0]
+	delete(h.keys, e.Key)
+	e.Key, e.Err = key, e.N
+	e.N += n
+	h.keys[key] = e
+	heap.Fix(&h.cur, 0)
+}
+
+// Counter returns a Counter which attributes the events it
+// counts to key, so that the bytes read or written by a
+// Reader or Writer can be attributed:
+//
+//	r := rate.NewReader(conn, rate.WithCounter(h.Counter(addr)))
+func (h *HeavyHitters) Counter(key string) Counter {
+	return hitterCounter{h, key}
+}
+
+type hitterCounter struct {
+	h   *HeavyHitters
+	key string
+}
+
+func (c hitterCounter) Add(n uint64) { c.h.Add(c.key, n) }
+
+// Top returns the top keys of the most recent complete
+// window, in decreasing order of N.
+func (h *HeavyHitters) Top() []HeavyHitter {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.rotate(time.Now())
+	return append([]HeavyHitter(nil), h.last...)
+}
+
+// rotate ends the current window if it is over.
+// h.mu must be held.
+func (h *HeavyHitters) rotate(now time.Time) {
+	elapsed := now.Sub(h.start)
+	if elapsed < h.window {
+		return
+	}
+	h.last = h.last[:0]
+	if elapsed < 2*h.window {
+		for _, e := range h.cur {
+			h.last = append(h.last, e.HeavyHitter)
+		}
+		sort.Slice(h.last, func(i, j int) bool { return h.last[i].N > h.last[j].N })
+		if len(h.last) > h.k {
+			h.last = h.last[:h.k]
+		}
+	}
+	// Otherwise, no events were counted
+	// in the last complete window.
+	h.cur = h.cur[:0]
+	h.keys = make(map[string]*hitter)
+	h.start = h.start.Add(elapsed - elapsed%h.window)
+}
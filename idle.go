@@ -0,0 +1,85 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"net"
+	"time"
+)
+
+type idleTimeoutError struct{}
+
+func (idleTimeoutError) Error() string   { return "rate: no progress within idle timeout" }
+func (idleTimeoutError) Timeout() bool   { return true }
+func (idleTimeoutError) Temporary() bool { return true }
+
+// ErrIdleTimeout is returned by Readers, Writers, and Conns
+// with an idle timeout when no bytes are transferred within
+// it. It implements net.Error, and its Timeout method
+// returns true.
+var ErrIdleTimeout net.Error = idleTimeoutError{}
+
+// WithIdleTimeout makes the Reader or Writer fail with
+// ErrIdleTimeout if a read or write makes no progress for d.
+// Time spent waiting for the limiter does not count, so a
+// stream which is merely throttled never times out, while
+// one whose peer is dead does, however low its limit.
+//
+// Since a blocked read or write cannot otherwise be
+// interrupted, the timeout only applies when the underlying
+// Reader or Writer has a SetReadDeadline or SetWriteDeadline
+// method respectively, as net.Conn and *os.File do. The
+// deadline is set before every read or write, and left in
+// place afterwards, so it replaces any deadline set
+// directly on the underlying net.Conn. To combine the idle
+// timeout with deadlines of their own, callers should wrap
+// the net.Conn in a Conn (see NewConn) and set its
+// deadlines instead; the earlier of the two then applies.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(s *stream) { s.idle = d }
+}
+
+// arm sets the deadline of the underlying Reader or Writer,
+// u, before a transfer, if s has an idle timeout. It returns
+// whether the deadline set was the idle deadline, rather than
+// an earlier deadline set by the user.
+func (s *stream) arm(u interface{}, read bool) bool {
+	if s.idle <= 0 {
+		return false
+	}
+	var set func(t time.Time) error
+	if read {
+		d, ok := u.(interface {
+			SetReadDeadline(t time.Time) error
+		})
+		if !ok {
+			return false
+		}
+		set = d.SetReadDeadline
+	} else {
+		d, ok := u.(interface {
+			SetWriteDeadline(t time.Time) error
+		})
+		if !ok {
+			return false
+		}
+		set = d.SetWriteDeadline
+	}
+	t := time.Now().Add(s.idle)
+	if user, _ := s.user.Load().(time.Time); !user.IsZero() && user.Before(t) {
+		set(user)
+		return false
+	}
+	return set(t) == nil
+}
+
+// idleErr converts err to ErrIdleTimeout if it is a
+// timeout, and the idle deadline was armed.
+func idleErr(armed bool, err error) error {
+	if ne, ok := err.(net.Error); armed && ok && ne.Timeout() {
+		return ErrIdleTimeout
+	}
+	return err
+}
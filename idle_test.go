@@ -0,0 +1,62 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestIdleTimeoutCopy tests that the idle timeout applies
+// when io.Copy would otherwise use the WriteTo fast path.
+func TestIdleTimeoutCopy(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.Write([]byte("hello"))
+		<-stop
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	f, err := ioutil.TempFile("", "rate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	r := NewReader(c, WithIdleTimeout(100*time.Millisecond))
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(f, r)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != ErrIdleTimeout {
+			t.Errorf("got error %v; want ErrIdleTimeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("io.Copy did not time out")
+	}
+}
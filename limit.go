@@ -5,8 +5,12 @@
 package rate
 
 import (
+	"bufio"
+	"context"
 	"io"
 	"math"
+	"runtime/trace"
+	"sync"
 	"time"
 )
 
@@ -14,114 +18,772 @@ const (
 	defaultQuantum = time.Millisecond * time.Duration(100)
 )
 
-type either interface {
-	io(p []byte) (n int, err error)
+// Inf is a rate which imposes no limit. It is accepted
+// everywhere a rate in units per second is, and is distinct
+// from 0, which causes any attempt to consume units to
+// block forever.
+const Inf = math.MaxUint64
+
+// A Limiter limits the rate at which abstract units
+// (such as bytes) may be consumed. WaitN blocks until
+// n units may be consumed, or until ctx is done, in
+// which case it returns a non-nil error.
+//
+// Readers and Writers accept any Limiter, so that
+// applications can substitute their own
+// implementations, or fakes in tests.
+type Limiter interface {
+	WaitN(ctx context.Context, n int) error
+}
+
+var _ Limiter = (*Pacer)(nil)
+
+type unlimited struct{}
+
+func (unlimited) WaitN(ctx context.Context, n int) error         { return nil }
+func (unlimited) take(ctx context.Context, max int) (int, error) { return max, nil }
+func (unlimited) refund(n int)                                   {}
+
+// Unlimited is a Limiter which imposes no limit. It allows
+// code to apply a Limiter unconditionally, and leave it to
+// configuration whether it does anything.
+var Unlimited Limiter = unlimited{}
+
+// An IdlePolicy determines what happens to the budget
+// of a limiter which goes unused, for example because
+// the stream it limits is idle for several quanta.
+type IdlePolicy int
+
+const (
+	// IdleReset discards any unused budget, so that a
+	// stream which has been idle may only transfer one
+	// quantum's worth of data before being throttled.
+	// This is the default.
+	IdleReset IdlePolicy = iota
+	// IdleAccumulate allows unused budget to accumulate
+	// as credit, up to a maximum number of units, which
+	// may be spent in a burst once the stream resumes.
+	IdleAccumulate
+	// IdleUnlimited allows unused budget to accumulate
+	// without bound.
+	IdleUnlimited
+)
+
+// A LimitOption configures optional behavior of a Pacer.
+type LimitOption func(p *Pacer)
+
+// WithQuantum sets the quantum of the Pacer. The Pacer
+// will attempt to control the rate by limiting the number
+// of units allowed every quantum to bps * quantum. Smaller
+// values of quantum will make the rate more smooth so long
+// as the units are consumed quickly enough and in large
+// enough batches. However, if quantum is too small, it may
+// slow the rate due to the overhead of many small calls.
+// The default value is 100ms.
+func WithQuantum(quantum time.Duration) LimitOption {
+	return func(p *Pacer) {
+		if quantum > 0 {
+			p.quantum = quantum
+		}
+	}
+}
+
+// WithIdlePolicy sets the policy used for budget which
+// goes unused. If p is IdleAccumulate, max is the maximum
+// number of units of credit which may accumulate; otherwise,
+// it is ignored.
+func WithIdlePolicy(p IdlePolicy, max uint64) LimitOption {
+	return func(l *Pacer) {
+		l.idle = p
+		l.maxCredit = max
+	}
 }
 
-type eitherReader struct {
-	r io.Reader
+// WithStrictPacing guarantees that the rate never exceeds
+// bps, even momentarily. Rather than allowing a quantum's
+// worth of units to be transferred at once at the start
+// of each quantum, every transfer of n units delays the
+// next by n / bps seconds, and time during which the
+// stream was stalled or idle earns no credit. This is
+// useful in front of consumers which drop data when
+// overrun, such as serial links. Idle policies have no
+// effect on strictly paced limiters.
+func WithStrictPacing() LimitOption {
+	return func(p *Pacer) { p.strict = true }
 }
 
-func (e eitherReader) io(p []byte) (int, error) { return e.r.Read(p) }
+// WithCatchUp makes the limiter target a long-term average
+// rate of bps rather than a maximum rate. The limiter keeps
+// track of the total number of units transferred since the
+// first transfer, and whenever the stream falls behind the
+// average (for example, after a stall), it allows a rate of
+// up to max units per second until it has caught up. If max
+// is less than bps, bps is used. Idle policies have no effect
+// on limiters created with this option.
+func WithCatchUp(max uint64) LimitOption {
+	return func(p *Pacer) {
+		p.catchUp = true
+		p.maxBPS = max
+	}
+}
 
-type eitherWriter struct {
-	w io.Writer
+// WithInitialBurst allows the first n units to be consumed
+// immediately, without regard to the limit; the limit applies
+// only once they have been consumed. This is the behavior
+// expected of media servers, which send the first few seconds
+// of a stream as fast as possible to fill the player's buffer,
+// and then pace the rest. See also NewMediaPacer.
+func WithInitialBurst(n uint64) LimitOption {
+	return func(p *Pacer) { p.burst = n }
 }
 
-func (e eitherWriter) io(p []byte) (int, error) { return e.w.Write(p) }
+// WithBurst makes the Pacer a token bucket which holds up to
+// burst units: the bucket starts full, and is refilled
+// continuously at the Pacer's rate, so that a stream which
+// has been idle may consume up to burst units at once before
+// it is limited to the steady rate. This is how most shapers
+// of bytes per second behave. A token bucket takes the place
+// of the Pacer's quanta, so WithBurst supersedes the idle
+// policy, WithStrictPacing, and WithCatchUp. If burst is 0,
+// the Pacer is not a token bucket.
+func WithBurst(burst uint64) LimitOption {
+	return func(p *Pacer) {
+		if burst > math.MaxInt32 {
+			burst = math.MaxInt32
+		}
+		p.bucket = int(burst)
+	}
+}
 
-// limit implements the abstract functionality
-// common between writers and readers
-type limit struct {
-	e      either
-	writer bool
+// WithParent makes the Pacer draw on the budget of parent
+// as well as its own, so that units consumed through it
+// count against both limits. For example, the Pacers of
+// several streams may share a parent which limits their
+// combined rate, while each limits its stream's rate.
+func WithParent(parent Limiter) LimitOption {
+	return func(p *Pacer) { p.parent = parent }
+}
 
-	bps     uint64 // units per second
-	quantum time.Duration
-	bpq     int // units per quantum
+// Child returns a new Pacer which allows bps units per
+// second, and draws on p's budget as well as its own, as
+// though created with WithParent(p). Children can be nested
+// to any depth, to express limits such as "each stream at
+// most 1MB/s, and all streams together at most 10MB/s":
+//
+//	total := rate.NewPacer(10 << 20)
+//	r1 := total.Child(1 << 20).Reader(a)
+//	r2 := total.Child(1 << 20).Reader(b)
+//
+// Units which a child consumes but does not use are
+// returned to its ancestors as well as to it.
+func (p *Pacer) Child(bps uint64, opts ...LimitOption) *Pacer {
+	return NewPacer(bps, append(opts[:len(opts):len(opts)], WithParent(p))...)
+}
 
-	t0   time.Time
-	left int
+// WithTraceRegions makes the Pacer mark the time goroutines
+// spend waiting for its budget as regions named "rate.wait"
+// in execution traces, so that go tool trace distinguishes
+// waiting for bandwidth from other sleeping. Waits are
+// attributed to the task of the context passed to WaitN,
+// if any.
+func WithTraceRegions() LimitOption {
+	return func(p *Pacer) { p.trace = true }
 }
 
-func newLimit(e either, writer bool, bps uint64, quantum time.Duration) limit {
-	if bps == 0 {
-		// Short-circuit so we don't divide by 0
+// mediaHeadroom is the factor by which NewMediaPacer
+// exceeds the bitrate, so that the player's buffer
+// slowly grows rather than running dry whenever the
+// network hiccups.
+const mediaHeadroom = 1.1
 
-		// It only matters that bps and e are set
-		// so that Read calls with n > 0 will block
-		// forever.
-		return limit{e: e, bps: bps}
+// NewMediaPacer returns a Pacer suited to delivering media
+// encoded at bitrate bytes per second. The first buffer's
+// worth of media is sent at full speed, and the rest is paced
+// slightly faster than it is played back. For example,
+//
+//	NewMediaPacer(bitrate, 10*time.Second)
+//
+// bursts the first ten seconds of media.
+func NewMediaPacer(bitrate uint64, buffer time.Duration, opts ...LimitOption) *Pacer {
+	burst := uint64(float64(bitrate) * buffer.Seconds())
+	bps := uint64(float64(bitrate) * mediaHeadroom)
+	if float64(bitrate)*mediaHeadroom >= Inf {
+		bps = Inf
 	}
-	ret := limit{
-		e:       e,
-		writer:  writer,
+	return NewPacer(bps, append([]LimitOption{WithInitialBurst(burst)}, opts...)...)
+}
+
+// A Pacer limits the rate at which abstract units (such
+// as bytes) may be consumed to a maximum of bps units per
+// second. It is safe for concurrent use; when it is shared
+// by several streams, their combined rate is limited.
+//
+// The zero value of Pacer imposes no limit, as though it
+// had been created with NewPacer(Inf).
+type Pacer struct {
+	valid bool // false for the zero value
+	clock Clock
+
+	bps     uint64        // units per second
+	q       time.Duration // the configured quantum
+	quantum time.Duration // the quantum in effect at bps
+	bpq     int           // units per quantum
+
+	idle      IdlePolicy
+	maxCredit uint64
+	strict    bool
+
+	catchUp bool
+	maxBPS  uint64
+	maxBPQ  int // units per quantum while catching up
+
+	burst  uint64 // units left in the initial burst
+	bucket int    // the size of the token bucket, if any
+	parent Limiter
+	trace  bool // whether waits are traced
+
+	mu    sync.Mutex
+	start time.Time // time of the first transfer
+	total uint64    // units consumed since start
+	t0    time.Time
+	left  int
+
+	charged int // units set aside by Precharge
+
+	// busy is set while a goroutine is waiting for the
+	// budget; the others wait in line in queue.
+	busy  bool
+	queue []chan struct{}
+
+	// changed, if non-nil, is closed when the
+	// rate changes.
+	changed chan struct{}
+}
+
+// NewPacer returns a new Pacer which allows bps units
+// per second. If bps == 0, any attempt to consume units
+// will block forever. If bps == Inf, no limit is imposed.
+func NewPacer(bps uint64, opts ...LimitOption) *Pacer {
+	p := &Pacer{
+		valid:   true,
+		clock:   systemClock{},
 		bps:     bps,
-		quantum: quantum,
-		bpq:     (int(bps) * int(quantum)) / int(time.Second),
+		quantum: defaultQuantum,
 	}
-	if ret.bpq == 0 {
-		ret.bpq = 1
-		ret.quantum = time.Second / time.Duration(ret.bps)
+	for _, o := range opts {
+		o(p)
 	}
-	return ret
+	p.q = p.quantum
+	p.derive()
+	return p
 }
 
-func (l *limit) io(p []byte) (n int, err error) {
-	if l.e == nil {
-		n, err = 0, io.EOF
+// derive computes the parameters which are derived
+// from p's rate. p.mu must be held if p is in use.
+func (p *Pacer) derive() {
+	p.quantum = p.q
+	if p.bps == 0 || p.bps == Inf {
+		// Short-circuit so we don't divide by 0,
+		// or overflow.
 		return
 	}
-	if len(p) == 0 {
+
+	p.bpq = int((p.bps * uint64(p.quantum)) / uint64(time.Second))
+	if p.bpq == 0 {
+		p.bpq = 1
+		p.quantum = time.Second / time.Duration(p.bps)
+	}
+	if p.catchUp {
+		maxBPS := p.maxBPS
+		if maxBPS < p.bps {
+			maxBPS = p.bps
+		}
+		p.maxBPQ = int((maxBPS * uint64(p.quantum)) / uint64(time.Second))
+		if p.maxBPQ < p.bpq {
+			p.maxBPQ = p.bpq
+		}
+	}
+}
+
+// SetRate changes the rate of p to bps units per second,
+// without disturbing the streams which use it, so that a
+// limit can be raised or lowered while transfers are in
+// progress. Any budget left in the current quantum in
+// excess of the new rate's is lost, and callers blocked by
+// a rate of 0 are woken. SetRate has no effect on the zero
+// value of Pacer, which never imposes a limit.
+func (p *Pacer) SetRate(bps uint64) {
+	if !p.valid {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bps = bps
+	p.derive()
+	if p.left > p.bpq && p.bucket == 0 {
+		p.left = p.bpq
+	}
+	// The long-term average is reset, since
+	// it was computed at the old rate.
+	p.start, p.total = time.Time{}, 0
+	if p.changed != nil {
+		close(p.changed)
+		p.changed = nil
+	}
+}
+
+// SetQuantum changes the quantum of p, as set by
+// WithQuantum, while it is in use. Any budget left in
+// the current quantum in excess of the new quantum's is
+// lost. If quantum is not positive, SetQuantum has no
+// effect; neither does it affect the zero value of Pacer.
+func (p *Pacer) SetQuantum(quantum time.Duration) {
+	if !p.valid || quantum <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.q = quantum
+	p.derive()
+	if p.left > p.bpq && p.bucket == 0 {
+		p.left = p.bpq
+	}
+}
+
+// Rate returns the rate of p, in units per second.
+// The zero value of Pacer has a rate of Inf.
+func (p *Pacer) Rate() uint64 {
+	if !p.valid {
+		return Inf
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.bps
+}
+
+// WaitN blocks until n units may be consumed, or until ctx
+// is done, in which case it returns ctx.Err(). If n is
+// larger than the budget for a single quantum, WaitN
+// consumes the budget for as many quanta as necessary.
+func (p *Pacer) WaitN(ctx context.Context, n int) error {
+	for got := 0; got < n; {
+		k, err := p.take(ctx, n-got)
+		if err != nil {
+			p.refund(got)
+			return err
+		}
+		got += k
+	}
+	return nil
+}
+
+// doneCtx is a context which is already done.
+var doneCtx = func() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}()
+
+// allow consumes n units if they may be consumed
+// without waiting, and reports whether it did.
+func (p *Pacer) allow(n int) bool {
+	for got := 0; got < n; {
+		k, err := p.take(doneCtx, n-got)
+		if err != nil {
+			p.refund(got)
+			return false
+		}
+		got += k
+	}
+	return true
+}
+
+// take blocks until at least one unit may be consumed,
+// and then consumes and returns up to max units, from
+// both p and its parent, if any.
+func (p *Pacer) take(ctx context.Context, max int) (int, error) {
+	if n := p.useCharge(max); n > 0 {
+		return n, nil
+	}
+	return p.takeFresh(ctx, max)
+}
+
+// takeFresh is like take, but ignores units set
+// aside by Precharge.
+func (p *Pacer) takeFresh(ctx context.Context, max int) (int, error) {
+	n, err := p.takeOwn(ctx, max)
+	if err != nil || p.parent == nil || n == 0 {
+		return n, err
+	}
+	if t, ok := p.parent.(taker); ok {
+		k, err := t.take(ctx, n)
+		p.refundOwn(n - k)
+		return k, err
+	}
+	if err := p.parent.WaitN(ctx, n); err != nil {
+		p.refundOwn(n)
+		return 0, err
+	}
+	return n, nil
+}
+
+// Precharge blocks until n units may be consumed, or until
+// ctx is done, as WaitN does, but rather than consuming them,
+// it sets them aside, so that later transfers through p can
+// consume them immediately. Callers which must react quickly
+// to an event, such as sending a latency-critical message,
+// can precharge during idle moments, so that the send is not
+// delayed by the limit at the worst possible moment. Units
+// which are precharged count against the limit when they are
+// precharged, not when they are consumed.
+func (p *Pacer) Precharge(ctx context.Context, n int) error {
+	for got := 0; got < n; {
+		k, err := p.takeFresh(ctx, n-got)
+		if err != nil {
+			p.refund(got)
+			return err
+		}
+		got += k
+	}
+	if p.valid {
+		p.mu.Lock()
+		p.charged += n
+		p.mu.Unlock()
+	}
+	return nil
+}
+
+// useCharge consumes up to max precharged units.
+func (p *Pacer) useCharge(max int) int {
+	if !p.valid || max <= 0 {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := p.charged
+	if max < n {
+		n = max
+	}
+	p.charged -= n
+	return n
+}
+
+// takeOwn is like take, but ignores p's parent.
+func (p *Pacer) takeOwn(ctx context.Context, max int) (int, error) {
+	if max <= 0 {
+		return 0, nil
+	}
+	if !p.valid {
+		return max, nil
+	}
+
+	// Goroutines wait for the budget one at a time, in
+	// the order in which they arrived, so that none can
+	// be starved by others which happen to be scheduled
+	// at the right moments.
+	p.mu.Lock()
+	if p.busy {
+		ch := make(chan struct{})
+		p.queue = append(p.queue, ch)
+		p.mu.Unlock()
+		end := p.region(ctx)
+		select {
+		case <-ch:
+			end()
+		case <-ctx.Done():
+			end()
+			if !p.dequeue(ch) {
+				// We were handed the turn
+				// just as ctx was done.
+				p.handOff()
+			}
+			return 0, ctx.Err()
+		}
+	} else {
+		p.busy = true
+		p.mu.Unlock()
+	}
+	defer p.handOff()
+
+	for {
+		p.mu.Lock()
+		switch p.bps {
+		case 0:
+			if p.changed == nil {
+				p.changed = make(chan struct{})
+			}
+			changed := p.changed
+			p.mu.Unlock()
+			end := p.region(ctx)
+			select {
+			case <-changed:
+				end()
+				continue
+			case <-ctx.Done():
+				end()
+				return 0, ctx.Err()
+			}
+		case Inf:
+			p.mu.Unlock()
+			return max, nil
+		}
+		now := p.clock.Now()
+		n, wait := p.reserve(now, max)
+		p.mu.Unlock()
+		if n > 0 {
+			return n, nil
+		}
+		if d, ok := ctx.Deadline(); ok && p.realTime() && now.Add(wait).After(d) {
+			// Don't wait for a budget which will
+			// not be available in time.
+			return 0, context.DeadlineExceeded
+		}
+		if err := p.sleep(ctx, wait); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// handOff ends the turn of the goroutine waiting
+// for p's budget, and gives it to the next in line.
+func (p *Pacer) handOff() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.queue) == 0 {
+		p.busy = false
 		return
 	}
-	if l.bps == 0 {
-		time.Sleep(time.Duration(math.MaxInt16))
+	close(p.queue[0])
+	p.queue = p.queue[1:]
+}
+
+// dequeue removes ch from the line of goroutines
+// waiting for p's budget. It returns false if ch
+// has already been handed the turn.
+func (p *Pacer) dequeue(ch chan struct{}) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, c := range p.queue {
+		if c == ch {
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			return true
+		}
 	}
+	return false
+}
 
-	if l.left == 0 {
-		// If there are no bytes left in this quantum,
-		// wait until the next one.
+// Waiting returns the number of goroutines blocked waiting
+// for p's budget. Goroutines are served in the order in
+// which they began waiting.
+func (p *Pacer) Waiting() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := len(p.queue)
+	if p.busy {
+		n++
+	}
+	return n
+}
 
-		// If l.t0 is the zero value of time.Time,
-		// (indicating that this is the first read)
-		// l.t0.Sub(time.Now()) < 0, and time.Sleep
-		// will return immediately.
-		time.Sleep(l.t0.Sub(time.Now()))
-		l.t0 = time.Now().Add(l.quantum)
-		l.left = l.bpq
+// realTime returns whether p uses the system clock, so
+// that its times may be compared with context deadlines.
+func (p *Pacer) realTime() bool {
+	_, ok := p.clock.(systemClock)
+	return ok
+}
+
+// region starts a trace region for a wait, if p traces
+// waits, and returns a function which ends it.
+func (p *Pacer) region(ctx context.Context) (end func()) {
+	if !p.trace {
+		return func() {}
+	}
+	return trace.StartRegion(ctx, "rate.wait").End
+}
+
+// sleep sleeps for d, or until ctx is done.
+func (p *Pacer) sleep(ctx context.Context, d time.Duration) error {
+	defer p.region(ctx)()
+	if _, ok := p.clock.(systemClock); !ok || ctx.Done() == nil {
+		p.clock.Sleep(d)
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reserve consumes up to max units at time now. If no
+// units may be consumed, it returns the time to wait
+// before trying again. p.mu must be held.
+func (p *Pacer) reserve(now time.Time, max int) (n int, wait time.Duration) {
+	if p.burst > 0 {
+		n = max
+		if uint64(n) > p.burst {
+			n = int(p.burst)
+		}
+		p.burst -= uint64(n)
+		p.total += uint64(n)
+		return n, 0
+	}
+	switch {
+	case p.bucket > 0:
+		return p.fill(now, max)
+	case p.strict:
+		// p.t0 is the earliest time at which
+		// the next transfer may begin.
+		if now.Before(p.t0) {
+			return 0, p.t0.Sub(now)
+		}
+		p.t0 = now
+		p.left = p.bpq
+		n = p.grant(max)
+		p.t0 = p.t0.Add(time.Duration(n) * time.Second / time.Duration(p.bps))
+		return n, 0
+	case now.Before(p.t0):
+		if p.left == 0 {
+			// If there are no units left in this
+			// quantum, wait until the next one.
+			return 0, p.t0.Sub(now)
+		}
+	case p.catchUp:
+		if p.start.IsZero() {
+			p.start = now
+		}
+		// The number of units which will be allowed
+		// by the end of the next quantum in order
+		// to maintain an average of p.bps.
+		allowed := uint64(float64(p.bps) * now.Add(p.quantum).Sub(p.start).Seconds())
+		p.t0 = now.Add(p.quantum)
+		p.left = 0
+		if allowed > p.total {
+			p.left = p.maxBPQ
+			if owed := allowed - p.total; owed < uint64(p.maxBPQ) {
+				p.left = int(owed)
+			}
+		}
+		if p.left == 0 {
+			return 0, p.quantum
+		}
+	default:
+		// The current quantum has expired. If p.t0 is the
+		// zero value of time.Time, this is the first call,
+		// and there is no unused budget to speak of.
+		var credit uint64
+		if !p.t0.IsZero() && p.idle != IdleReset {
+			credit = uint64(p.left) + uint64(now.Sub(p.t0)/p.quantum)*uint64(p.bpq)
+			if p.idle == IdleAccumulate && credit > p.maxCredit {
+				credit = p.maxCredit
+			}
+			if credit > math.MaxInt32 {
+				credit = math.MaxInt32
+			}
+		}
+		p.t0 = now.Add(p.quantum)
+		p.left = p.bpq + int(credit)
 	}
+	return p.grant(max), 0
+}
 
-	buf := p
-	if len(p) > l.left {
-		// fmt.Fprintf(os.Stderr, "%v %v\n", len(p), l.left)
-		buf = p[:l.left]
+// fill is like reserve, for a Pacer which is a token
+// bucket. p.left is the number of tokens in the bucket,
+// and p.t0 the time up to which it has been refilled.
+// p.mu must be held.
+func (p *Pacer) fill(now time.Time, max int) (n int, wait time.Duration) {
+	perUnit := float64(time.Second) / float64(p.bps)
+	if p.t0.IsZero() {
+		p.left, p.t0 = p.bucket, now
+	} else if now.After(p.t0) {
+		add := float64(now.Sub(p.t0)) / perUnit
+		if add >= float64(p.bucket-p.left) {
+			p.left, p.t0 = p.bucket, now
+		} else {
+			// Only advance p.t0 by the time taken to
+			// refill whole units, so that fractions of
+			// units are not lost.
+			k := int(add)
+			p.left += k
+			p.t0 = p.t0.Add(time.Duration(float64(k) * perUnit))
+		}
+	}
+	if p.left > 0 {
+		return p.grant(max), 0
 	}
-	n, err = l.e.io(buf)
-	l.left -= n
-	if l.writer && err == nil {
-		var ntmp int
-		ntmp, err = l.io(p[len(buf):])
-		n += ntmp
+	// Wait until the bucket holds enough units to
+	// be worth waking for.
+	need := max
+	if need > p.bpq {
+		need = p.bpq
 	}
-	return
+	if need > p.bucket {
+		need = p.bucket
+	}
+	wait = p.t0.Add(time.Duration(float64(need) * perUnit)).Sub(now)
+	if wait < time.Microsecond {
+		wait = time.Microsecond
+	}
+	return 0, wait
 }
 
-type limitReader struct {
-	l limit
+// grant consumes up to max of the units left in
+// the current quantum. p.mu must be held.
+func (p *Pacer) grant(max int) int {
+	n := p.left
+	if max < n {
+		n = max
+	}
+	p.left -= n
+	p.total += uint64(n)
+	return n
 }
 
-func (l *limitReader) Read(p []byte) (n int, err error) {
-	n, err = l.l.io(p)
-	return
+// refund returns n units which were consumed
+// but not used.
+func (p *Pacer) refund(n int) {
+	p.refundOwn(n)
+	if t, ok := p.parent.(taker); ok && n > 0 {
+		t.refund(n)
+	}
+}
+
+// refundOwn is like refund, but ignores p's parent.
+func (p *Pacer) refundOwn(n int) {
+	if n <= 0 || !p.valid {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refundLocked(n)
+}
+
+// refundLocked is like refundOwn, but p.mu must be held.
+func (p *Pacer) refundLocked(n int) {
+	if p.bps == 0 || p.bps == Inf || uint64(n) > p.total {
+		return
+	}
+	p.total -= uint64(n)
+	if p.strict {
+		p.t0 = p.t0.Add(-time.Duration(n) * time.Second / time.Duration(p.bps))
+		return
+	}
+	p.left += n
+	if p.bucket > 0 && p.left > p.bucket {
+		p.left = p.bucket
+	}
 }
 
 // NewLimitReader returns a new Reader that reads from
 // r at a maximum rate of bps bytes per second. If
 // bps == 0, any call to Read with len(p) > 0 will
-// sleep forever.
-func NewLimitReader(r io.Reader, bps uint64) io.Reader {
-	return NewLimitReaderQuantum(r, bps, defaultQuantum)
+// sleep forever. If bps == Inf, no limit is imposed.
+// The rate may be changed later with SetRate.
+func NewLimitReader(r io.Reader, bps uint64, opts ...LimitOption) *Reader {
+	return NewReader(r, WithLimiter(NewPacer(bps, opts...)))
 }
 
 // NewLimitReaderQuantum creates a new Reader that
@@ -138,24 +800,50 @@ func NewLimitReader(r io.Reader, bps uint64) io.Reader {
 // quantum is too small, it may slow the rate
 // due to the overhead of many small read calls.
 // The default value (used by NewLimitReader) is 100ms.
-func NewLimitReaderQuantum(r io.Reader, bps uint64, quantum time.Duration) io.Reader {
-	return &limitReader{newLimit(eitherReader{r}, false, bps, quantum)}
+func NewLimitReaderQuantum(r io.Reader, bps uint64, quantum time.Duration, opts ...LimitOption) *Reader {
+	return NewLimitReader(r, bps, append(opts[:len(opts):len(opts)], WithQuantum(quantum))...)
 }
 
-type limitWriter struct {
-	l limit
+// NewBufferedLimitReader is like NewLimitReaderQuantum, except
+// that it reads from r through an internal buffer of the given
+// size. A limited Reader returns short reads whenever a read
+// would exceed the budget for the current quantum, and when
+// the limited Reader is itself read through a buffer (such as
+// a bufio.Reader), these short reads are passed on to r, which
+// defeats the purpose of buffering and can multiply the number
+// of system calls. The Reader returned by NewBufferedLimitReader
+// instead reads from r in chunks of up to size bytes, and
+// serves the short reads from its buffer; the rate at which
+// data is returned to the caller is still limited to bps.
+//
+// If size is less than or equal to zero, the budget for a
+// single quantum, or 4096 bytes, whichever is larger, is used.
+func NewBufferedLimitReader(r io.Reader, bps uint64, quantum time.Duration, size int, opts ...LimitOption) *Reader {
+	p := NewPacer(bps, append(opts[:len(opts):len(opts)], WithQuantum(quantum))...)
+	if size <= 0 {
+		size = p.bpq
+		if size < 4096 {
+			size = 4096
+		}
+	}
+	return NewReader(bufio.NewReaderSize(r, size), WithLimiter(p))
 }
 
-func (l *limitWriter) Write(p []byte) (n int, err error) {
-	n, err = l.l.io(p)
-	return
+// NewLimitReaderBurst is like NewLimitReader, except that
+// the rate is limited by a token bucket which holds up to
+// burst bytes (see WithBurst), so that a Reader which has
+// been idle may read up to burst bytes at once.
+func NewLimitReaderBurst(r io.Reader, bps, burst uint64, opts ...LimitOption) *Reader {
+	return NewLimitReader(r, bps, append(opts[:len(opts):len(opts)], WithBurst(burst))...)
 }
 
 // NewLimitWriter returns a new Writer that writes to w
 // at a maximum rate of bps bytes per second. If bps == 0,
 // any call to Write with len(p) > 0 will sleep forever.
-func NewLimitWriter(w io.Writer, bps uint64) io.Writer {
-	return NewLimitWriterQuantum(w, bps, defaultQuantum)
+// If bps == Inf, no limit is imposed. The rate may
+// be changed later with SetRate.
+func NewLimitWriter(w io.Writer, bps uint64, opts ...LimitOption) *Writer {
+	return NewWriter(w, WithLimiter(NewPacer(bps, opts...)))
 }
 
 // NewLimitWriterQuantum creates a new Writer that
@@ -175,6 +863,14 @@ func NewLimitWriter(w io.Writer, bps uint64) io.Writer {
 // quantum is too small, it may slow the rate
 // due to the overhead of many small read calls.
 // The default value (used by NewLimitWriter) is 100ms.
-func NewLimitWriterQuantum(w io.Writer, bps uint64, quantum time.Duration) io.Writer {
-	return &limitWriter{newLimit(eitherWriter{w}, true, bps, quantum)}
+func NewLimitWriterQuantum(w io.Writer, bps uint64, quantum time.Duration, opts ...LimitOption) *Writer {
+	return NewLimitWriter(w, bps, append(opts[:len(opts):len(opts)], WithQuantum(quantum))...)
+}
+
+// NewLimitWriterBurst is like NewLimitWriter, except that
+// the rate is limited by a token bucket which holds up to
+// burst bytes (see WithBurst), so that a Writer which has
+// been idle may write up to burst bytes at once.
+func NewLimitWriterBurst(w io.Writer, bps, burst uint64, opts ...LimitOption) *Writer {
+	return NewLimitWriter(w, bps, append(opts[:len(opts):len(opts)], WithBurst(burst))...)
 }
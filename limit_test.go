@@ -0,0 +1,118 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestBurst tests that a token bucket allows bursts of
+// up to its size, and otherwise holds its rate.
+func TestBurst(t *testing.T) {
+	p := NewPacer(1000, WithBurst(200))
+	now := time.Now()
+
+	// allow consumes as many units as are available
+	// at each millisecond of d after start.
+	allow := func(start time.Time, d time.Duration) (n int) {
+		for i := time.Duration(0); i <= d; i += time.Millisecond {
+			for p.AllowN(start.Add(i), 1) {
+				n++
+			}
+		}
+		return n
+	}
+
+	if n := allow(now, 0); n != 200 {
+		t.Errorf("initial burst of %d units; want 200", n)
+	}
+	if n := allow(now.Add(time.Millisecond), time.Second-time.Millisecond); n < 990 || n > 1000 {
+		t.Errorf("%d units in the second after the burst; want 1000", n)
+	}
+	// An idle bucket fills up, but no further.
+	now = now.Add(10 * time.Second)
+	if n := allow(now, 0); n != 200 {
+		t.Errorf("burst of %d units after idling; want 200", n)
+	}
+	if p.AllowN(now, 1) {
+		t.Error("unit allowed beyond the burst")
+	}
+	if p.AllowN(now.Add(time.Second), 201) {
+		t.Error("more units than the bucket holds allowed at once")
+	}
+}
+
+// TestWaitOrder tests that goroutines are given p's
+// budget in the order in which they began waiting, and
+// that those which give up waiting, whether at the
+// head of the line or in it, do not hold up the rest.
+func TestWaitOrder(t *testing.T) {
+	const n = 8
+	p := NewPacer(0, WithQuantum(10*time.Millisecond))
+
+	var (
+		mu    sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+	cancels := make([]context.CancelFunc, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancels[i] = cancel
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if errs[i] = p.WaitN(ctx, 1); errs[i] == nil {
+				mu.Lock()
+				order = append(order, i)
+				mu.Unlock()
+			}
+		}(i)
+		for p.Waiting() < i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// Cancel the goroutine whose turn it is, and
+	// one still in line.
+	cancelled := map[int]bool{0: true, 3: true}
+	for i := range cancelled {
+		cancels[i]()
+	}
+	for p.Waiting() > n-len(cancelled) {
+		time.Sleep(time.Millisecond)
+	}
+	p.SetRate(100)
+	wg.Wait()
+
+	var want []int
+	for i := 0; i < n; i++ {
+		if cancelled[i] {
+			if errs[i] != context.Canceled {
+				t.Errorf("waiter %d: got error %v; want %v", i, errs[i], context.Canceled)
+			}
+			continue
+		}
+		want = append(want, i)
+	}
+	if len(order) != len(want) {
+		t.Fatalf("got order %v; want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("got order %v; want %v", order, want)
+		}
+	}
+	if w := p.Waiting(); w != 0 {
+		t.Errorf("got %d waiting after all returned; want 0", w)
+	}
+	for _, cancel := range cancels {
+		cancel()
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import "net"
+
+// A Listener wraps a net.Listener, limiting the rates at
+// which the connections it accepts are read from and written
+// to, both individually and in total.
+type Listener struct {
+	net.Listener
+
+	perConn     uint64
+	read, write *Pacer // the limits of all connections together
+	opts        []LimitOption
+}
+
+// NewLimitListener returns a new Listener whose connections
+// are each read from and written to at a maximum rate of
+// perConnBPS bytes per second, while all of them together
+// are read from and written to at a maximum rate of totalBPS
+// bytes per second. Reading and writing are limited
+// separately. Either limit may be Inf. opts configure the
+// Pacers of each connection and of the total.
+//
+// Since it is a net.Listener, bandwidth shaping can be added
+// to a server with one line:
+//
+//	l = rate.NewLimitListener(l, 1<<20, 10<<20)
+//	http.Serve(l, handler)
+//
+// The connections accepted are *Conns.
+func NewLimitListener(l net.Listener, perConnBPS, totalBPS uint64, opts ...LimitOption) *Listener {
+	return &Listener{
+		Listener: l,
+		perConn:  perConnBPS,
+		read:     NewPacer(totalBPS, opts...),
+		write:    NewPacer(totalBPS, opts...),
+		opts:     opts,
+	}
+}
+
+// Accept waits for and returns the next connection,
+// wrapped so that its rates are limited.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(c,
+		[]Option{WithLimit(l.perConn, l.withParent(l.read)...)},
+		[]Option{WithLimit(l.perConn, l.withParent(l.write)...)},
+	), nil
+}
+
+func (l *Listener) withParent(parent *Pacer) []LimitOption {
+	return append(l.opts[:len(l.opts):len(l.opts)], WithParent(parent))
+}
+
+// SetRate changes the limit of all of the connections
+// accepted by l together, both those already accepted
+// and those to come, to totalBPS bytes per second.
+func (l *Listener) SetRate(totalBPS uint64) {
+	l.read.SetRate(totalBPS)
+	l.write.SetRate(totalBPS)
+}
@@ -0,0 +1,77 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import "sync/atomic"
+
+// A LocalCounter counts events for a Monitor from a single
+// goroutine, for loops so hot that contention on the Monitor's
+// shared total would show up in profiles. Events are counted
+// locally, and added to the Monitor's total in batches, and at
+// the end of each of the Monitor's periods, so that its rates
+// are as accurate as though the events had been counted by
+// the Monitor directly. However, Total may not include up to
+// a batch of events per LocalCounter.
+//
+// A LocalCounter's Add method should be called by only one
+// goroutine at a time; each goroutine should use its own.
+type LocalCounter struct {
+	pending uint64 // accessed atomically
+	m       *Monitor
+	batch   uint64
+
+	// Keep the counters of different goroutines
+	// on different cache lines.
+	_ [64]byte
+}
+
+var _ Counter = (*LocalCounter)(nil)
+
+// Local returns a new LocalCounter for m, which adds to m's
+// total whenever it has counted at least batch events. The
+// LocalCounter should be closed once it is no longer used.
+func (m *Monitor) Local(batch uint64) *LocalCounter {
+	l := &LocalCounter{m: m, batch: batch}
+	m.mu.Lock()
+	if m.locals == nil {
+		m.locals = make(map[*LocalCounter]struct{})
+	}
+	m.locals[l] = struct{}{}
+	m.mu.Unlock()
+	return l
+}
+
+// Add counts n events.
+func (l *LocalCounter) Add(n uint64) {
+	if atomic.AddUint64(&l.pending, n) >= l.batch {
+		l.flush()
+	}
+}
+
+// flush adds the events counted by l to its Monitor.
+func (l *LocalCounter) flush() {
+	if n := atomic.SwapUint64(&l.pending, 0); n > 0 {
+		l.m.Add(n)
+	}
+}
+
+// Close adds any events counted by l to its Monitor,
+// and detaches l from it. l must not be used afterwards.
+func (l *LocalCounter) Close() {
+	l.m.mu.Lock()
+	delete(l.m.locals, l)
+	l.m.mu.Unlock()
+	l.flush()
+}
+
+// flushLocals adds the events counted by m's
+// LocalCounters to its total.
+func (m *Monitor) flushLocals() {
+	m.mu.Lock()
+	for l := range m.locals {
+		l.flush()
+	}
+	m.mu.Unlock()
+}
@@ -0,0 +1,98 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// A LogWriter limits the rate at which log output is written
+// to an underlying io.Writer, so that a storm of log messages
+// cannot flood the disk or a log collector. Messages over the
+// limit are dropped, and the next message which is written is
+// preceded by a summary of how many were dropped:
+//
+//	log.SetOutput(rate.NewLogWriter(os.Stderr, 100))
+//
+// Each call to Write is treated as a single message, as it is
+// by the log package. It is safe for concurrent use.
+type LogWriter struct {
+	w     io.Writer
+	p     *Pacer
+	bytes bool
+
+	mu         sync.Mutex
+	suppressed uint64
+}
+
+// NewLogWriter returns a new LogWriter which writes at most
+// lps messages per second to w, as allowed by a Pacer created
+// with NewPacer(lps, opts...). Since messages are not delayed,
+// but dropped, the Pacer should allow bursts; for example, with
+// WithIdlePolicy(IdleAccumulate, lps).
+func NewLogWriter(w io.Writer, lps uint64, opts ...LimitOption) *LogWriter {
+	return &LogWriter{w: w, p: NewPacer(lps, opts...)}
+}
+
+// NewLogBytesWriter is like NewLogWriter, but limits the
+// output to bps bytes per second, rather than a number of
+// messages. Messages which do not fit are dropped whole.
+func NewLogBytesWriter(w io.Writer, bps uint64, opts ...LimitOption) *LogWriter {
+	return &LogWriter{w: w, p: NewPacer(bps, opts...), bytes: true}
+}
+
+// Write writes p as a single message, unless doing so would
+// exceed l's limit, in which case p is dropped. In either
+// case, the error from the underlying io.Writer, if any, is
+// returned; dropping a message is not an error.
+func (l *LogWriter) Write(p []byte) (n int, err error) {
+	cost := 1
+	if l.bytes {
+		cost = len(p)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.p.allow(cost) {
+		l.suppressed++
+		return len(p), nil
+	}
+	if err = l.summarize(); err != nil {
+		return 0, err
+	}
+	return l.w.Write(p)
+}
+
+// Suppressed returns the number of messages dropped since
+// the last summary was written.
+func (l *LogWriter) Suppressed() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.suppressed
+}
+
+// Flush writes the summary of the messages dropped since
+// the last summary, if any, without waiting for the next
+// message to be written. It may be called periodically,
+// or once the application is done logging.
+func (l *LogWriter) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.summarize()
+}
+
+// summarize writes a summary of the suppressed
+// messages, if there are any. l.mu must be held.
+func (l *LogWriter) summarize() error {
+	if l.suppressed == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintf(l.w, "rate: suppressed %d log messages\n", l.suppressed)
+	if err == nil {
+		l.suppressed = 0
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrBudgetExhausted is returned by Readers and Writers with
+// a maximum wait (see WithMaxWait) when their limiter does
+// not allow any more bytes to be transferred within it.
+var ErrBudgetExhausted = errors.New("rate: budget exhausted")
+
+// WithMaxWait bounds the time for which a read or write waits
+// for the Reader or Writer's limiter. If the limiter allows
+// fewer bytes than requested within d, a read returns only
+// those bytes, and a write writes only those bytes and returns
+// ErrBudgetExhausted; if it allows none, the read or write
+// returns ErrBudgetExhausted without transferring anything.
+// This lets latency-sensitive callers decide for themselves
+// what to do when the stream is throttled. A maximum wait of
+// 0 means that reads and writes never wait.
+//
+// A *Pacer fails at once, rather than after d, if it can tell
+// that its budget will not be available within d. Readers
+// with Limiters other than *Pacer wait after reading, and so
+// cannot bound the wait.
+func WithMaxWait(d time.Duration) Option {
+	return func(s *stream) {
+		s.maxWait = d
+		s.bounded = true
+	}
+}
+
+// waitContext returns the context with which s waits
+// for its limiter, a function which ends the wait and
+// reports whether it was interrupted by a change to the
+// deadline of s's Conn, and that deadline, if the context
+// is subject to it.
+func (s *stream) waitContext() (context.Context, func() bool, time.Time) {
+	if s.bounded && s.maxWait <= 0 {
+		return doneCtx, func() bool { return false }, time.Time{}
+	}
+	ctx, stop := s.context(), func() bool { return false }
+	var deadline time.Time
+	if s.conn != nil {
+		ctx, stop, deadline = s.conn.wait(ctx)
+	}
+	if !s.bounded {
+		return ctx, stop, deadline
+	}
+	if !deadline.IsZero() && deadline.After(time.Now().Add(s.maxWait)) {
+		// The maximum wait comes first.
+		deadline = time.Time{}
+	}
+	bctx, bcancel := context.WithTimeout(ctx, s.maxWait)
+	return bctx, func() bool { bcancel(); return stop() }, deadline
+}
+
+// budgetErr converts the error returned when a bounded
+// wait for s's limiter times out to ErrBudgetExhausted.
+func (s *stream) budgetErr(err error) error {
+	if s.ctx != nil && s.ctx.Err() != nil {
+		return s.ctx.Err()
+	}
+	if s.bounded && (err == context.DeadlineExceeded || err == context.Canceled) {
+		return ErrBudgetExhausted
+	}
+	return err
+}
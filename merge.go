@@ -0,0 +1,130 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import "math"
+
+// SumRates combines Rates reported over the same period by
+// different Monitors (for example, one for each connection
+// of a server) into the Rate of all of them together: the
+// totals and rates are summed. The Time and Interval of the
+// result are those of the latest of rs, and its Gap is the
+// largest of theirs. The result carries the labels which
+// all of rs share.
+func SumRates(rs ...Rate) Rate {
+	var s Rate
+	for i, r := range rs {
+		s.Total += r.Total
+		s.Rate += r.Rate
+		s.Wasted += r.Wasted
+		s.WasteRate += r.WasteRate
+		if r.Time.After(s.Time) {
+			s.Time, s.Interval = r.Time, r.Interval
+		}
+		if r.Gap > s.Gap {
+			s.Gap = r.Gap
+		}
+		if i == 0 {
+			s.Labels = r.Labels
+		} else {
+			s.Labels = commonLabels(s.Labels, r.Labels)
+		}
+	}
+	return s
+}
+
+// RollupRates combines Rates reported over consecutive
+// periods by the same Monitor into the Rate over all of the
+// periods together. The rates are averaged, weighted by the
+// Interval of each period, so that a long period counts for
+// more than a short one. The Total, Wasted, Time, and Labels
+// of the result are those of the latest of rs; its Interval
+// and Gap are the sums of theirs.
+func RollupRates(rs ...Rate) Rate {
+	var s Rate
+	var n, w float64
+	for _, r := range rs {
+		d := r.Interval.Seconds()
+		n += r.Rate * d
+		w += r.WasteRate * d
+		s.Interval += r.Interval
+		s.Gap += r.Gap
+		if !r.Time.Before(s.Time) {
+			s.Total, s.Wasted, s.Time, s.Labels = r.Total, r.Wasted, r.Time, r.Labels
+		}
+	}
+	if s.Interval > 0 {
+		s.Rate = n / s.Interval.Seconds()
+		s.WasteRate = w / s.Interval.Seconds()
+	}
+	return s
+}
+
+// commonLabels returns the labels which a and b share.
+func commonLabels(a, b Labels) Labels {
+	var c Labels
+	for k, v := range a {
+		if bv, ok := b[k]; ok && bv == v {
+			if c == nil {
+				c = make(Labels)
+			}
+			c[k] = v
+		}
+	}
+	return c
+}
+
+// MergeStats combines Stats computed over disjoint sets of
+// periods (for example, by different Monitors, or by the
+// same Monitor before and after a restart) into the Stats
+// which would have been computed over all of the periods
+// together. Each period counts equally, regardless of which
+// of ss it was included in. The Elapsed times of ss are
+// summed, as though their periods were consecutive.
+func MergeStats(ss ...Stats) Stats {
+	var m Stats
+	var events float64
+	for _, s := range ss {
+		if s.Periods == 0 {
+			continue
+		}
+		if m.Periods == 0 || s.Peak > m.Peak {
+			m.Peak = s.Peak
+		}
+		if s.Min != 0 && (m.Min == 0 || s.Min < m.Min) {
+			m.Min = s.Min
+		}
+		m.Periods += s.Periods
+		m.Elapsed += s.Elapsed
+		events += s.Average * s.Elapsed.Seconds()
+	}
+	if m.Periods == 0 {
+		return m
+	}
+	if m.Elapsed > 0 {
+		m.Average = events / m.Elapsed.Seconds()
+	}
+	for _, s := range ss {
+		m.Mean += s.Mean * float64(s.Periods)
+	}
+	m.Mean /= float64(m.Periods)
+
+	// Combine the sums of squared deviations from
+	// each mean, as in the parallel variant of
+	// Welford's algorithm.
+	var m2 float64
+	for _, s := range ss {
+		if s.Periods == 0 {
+			continue
+		}
+		d := s.Mean - m.Mean
+		m2 += s.Variance*float64(s.Periods-1) + d*d*float64(s.Periods)
+	}
+	if m.Periods > 1 {
+		m.Variance = m2 / float64(m.Periods-1)
+		m.StdDev = math.Sqrt(m.Variance)
+	}
+	return m
+}
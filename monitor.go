@@ -2,25 +2,118 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// Copyright 2014 The Authors. All rights reserved.
-// Use of this source code is governed by a BSD-style
-// license that can be found in the LICENSE file.
-
 package rate
 
 import (
+	"context"
+	"errors"
 	"io"
+	"math"
+	"runtime/trace"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 const (
-	defaultPeroid = time.Duration(500) * time.Millisecond
+	defaultPeriod = time.Duration(500) * time.Millisecond
 )
 
 type Rate struct {
 	Total uint64
 	Rate  float64
+
+	// Time is the time at which the rate was computed.
+	Time time.Time
+	// Interval is the amount of time over which
+	// Rate was computed.
+	Interval time.Duration
+	// Wasted is the total number of wasted events (such
+	// as retransmitted bytes) so far, and WasteRate is
+	// the rate at which they happened over the period.
+	// They are not included in Total and Rate, which
+	// count only useful events. The overall rate of
+	// events, useful or not, is Rate + WasteRate.
+	Wasted    uint64
+	WasteRate float64
+	// EWMA is an exponentially weighted moving average
+	// of Rate (see WithEWMA), and MovingAverage is the
+	// average rate over the last few periods (see
+	// WithMovingAverage). They are smoother than Rate,
+	// which makes them better suited to dashboards and
+	// progress bars. They are 0 unless configured.
+	EWMA          float64
+	MovingAverage float64
+	// Expected is the total number of events expected
+	// (see WithExpected), or 0 if it is not known. If it
+	// is known, Progress is the fraction of them which
+	// have happened, from 0 to 1, and ETA is the time
+	// remaining until they all have at the current rate,
+	// smoothed as for EWMA. ETA is negative if it cannot
+	// be estimated, because no events are happening.
+	Expected uint64
+	Progress float64
+	ETA      time.Duration
+	// RateError is the standard error of Rate, if it
+	// was estimated from events counted by sampling
+	// (see SampledCounter), and 0 otherwise.
+	RateError float64
+
+	// Labels are the labels the Monitor carried when
+	// the rate was computed (see WithLabels). They must
+	// not be modified.
+	Labels Labels
+	// Tags holds the rate over the period of the events
+	// counted with each tag given to AddTagged. Tags
+	// with no events in the period are omitted.
+	Tags map[string]float64
+
+	// Gap is the amount of time by which the period
+	// overran when the monitor could not account for
+	// the elapsed time (for example, because the
+	// machine was suspended). It is only set when the
+	// Monitor was created with the GapFlag policy.
+	Gap time.Duration
+}
+
+// A Counter counts abstract events. Readers and Writers
+// accept any Counter, so that applications can substitute
+// their own implementations, or fakes in tests.
+type Counter interface {
+	Add(n uint64)
+}
+
+var _ Counter = (*Monitor)(nil)
+
+// A GapPolicy determines how a Monitor treats a period
+// which took much longer than expected, as happens when
+// the machine is suspended or the monitoring goroutine
+// is starved. Any period which takes more than twice the
+// configured period is considered to contain a gap.
+type GapPolicy int
+
+const (
+	// GapSpread treats the events counted during the
+	// period as having happened evenly over the entire
+	// elapsed time, as though no gap had occurred.
+	GapSpread GapPolicy = iota
+	// GapDrop adds the events counted during the period
+	// to the total, but does not report a rate for it.
+	GapDrop
+	// GapFlag computes the rate as though the period
+	// had lasted only as long as configured, and sets
+	// the Gap field of the reported Rate to the time
+	// which was not accounted for.
+	GapFlag
+)
+
+// A MonitorOption configures optional behavior of a Monitor.
+type MonitorOption func(m *Monitor)
+
+// WithGapPolicy sets the policy used for periods which
+// contain a gap. The default is GapSpread.
+func WithGapPolicy(p GapPolicy) MonitorOption {
+	return func(m *Monitor) { m.gap = p }
 }
 
 // A Monitor monitors the rate at which abstract events
@@ -30,53 +123,301 @@ type Rate struct {
 // over the preceding period and the total number of events
 // so far are either written to a channel or given as the
 // argument to a function.
+//
+// The zero value of Monitor is a Monitor in pull mode:
+// it counts events, and their total may be retrieved
+// with Total, but it does not run a goroutine or report
+// rates. It need not be closed.
 type Monitor struct {
+	// total and wasted are accessed atomically,
+	// and so must be 64-bit aligned, as must
+	// variance, which holds the float64 bits of
+	// the variance of the sampled events in the
+	// current period (see SampledCounter).
+	total, wasted uint64
+	variance      uint64
+	expected      uint64 // accessed atomically; see WithExpected
+
 	f      func(r Rate)
 	period time.Duration
+	// idleEvery is the number of consecutive idle
+	// periods per report, or -1 if idle periods are
+	// never reported; 0 means every period is reported.
+	idleEvery int
+	idle      int // consecutive idle periods so far
+	trace     bool
+	// slow, if nonzero, is the period used while
+	// the Monitor is idle, and cur is the length
+	// of the current period, if it is not period.
+	slow, cur time.Duration
+
+	// wake, if non-nil, is signaled by the first
+	// event after an idle period, which is reported
+	// immediately. asleep is accessed atomically,
+	// and is 1 if the last period was idle.
+	wake   chan struct{}
+	asleep uint32
+	gap    GapPolicy
 	t0     time.Time
-	n, nn  uint64
-	exit   chan struct{}
+
+	detector Detector
+	anomaly  func(a Anomaly)
+
+	smoothing smoothing
+
+	// scale, if nonzero, converts events per second
+	// into the units in which rates are reported.
+	scale float64
+	// before, if non-nil, is called at the end of
+	// each period before the total is read.
+	before func(t time.Time)
+
+	n, w    uint64 // total and wasted as of the last period
+	exit    chan struct{}
+	stopped func()          // if non-nil, called when the Monitor stops
+	done    <-chan struct{} // closed when the Monitor's context is done
+	running uint32          // accessed atomically; whether monitor has been called
+
+	tagMu sync.Mutex
+	tags  map[string]uint64 // events counted by AddTagged this period
+
+	// mu protects the statistics below, which are
+	// read by methods called from other goroutines.
+	mu       sync.Mutex
+	labels   Labels
+	locals   map[*LocalCounter]struct{}
+	last     Rate // the most recently reported rate
+	periods  uint64
+	mean, m2 float64
+	peak     float64
+	min      float64 // the lowest nonzero rate
+	started  time.Time
+	events   int64 // events counted in the periods
+	window   []float64
+	wi, wn   int
+	recent   []Rate // the last reported rates, oldest at ri when full
+	ri, rn   int
+	tiers    []*tier
+}
+
+// WithPeriod sets the period at which the Monitor
+// reports. If period == 0, the default period of
+// 500ms will be used.
+func WithPeriod(period time.Duration) MonitorOption {
+	return func(m *Monitor) {
+		if period == 0 {
+			period = defaultPeriod
+		}
+		m.period = period
+	}
+}
+
+// WithLabels attaches labels (such as the tenant or route
+// to which the events belong) to the Monitor. They are
+// included in every Rate it reports, so that exporters
+// (such as package promexport) can break down rates by
+// them. They may be changed later with SetLabels.
+func WithLabels(labels Labels) MonitorOption {
+	return func(m *Monitor) { m.labels = labels.clone() }
+}
+
+// WithIdleReports limits the reports made for idle periods,
+// in which no events (useful or wasted) happened, so that
+// long-lived, mostly idle streams do not flood sinks with
+// reports of a zero rate. Only every nth consecutive idle
+// period is reported; if n <= 0, idle periods are never
+// reported. The first period with activity after an idle
+// stretch is always reported. Idle periods are still
+// included in statistics such as Stats.
+func WithIdleReports(n int) MonitorOption {
+	return func(m *Monitor) {
+		if n <= 0 {
+			n = -1
+		}
+		m.idleEvery = n
+	}
+}
+
+// WithRateUnit makes the Monitor report rates in events per
+// unit rather than per second; for example, WithRateUnit(
+// time.Minute) reports rates in events per minute. The unit
+// applies to every rate computed by the Monitor, including
+// those returned by CurrentRate, Stats, and HistoryRange.
+func WithRateUnit(unit time.Duration) MonitorOption {
+	return func(m *Monitor) {
+		m.scale = 0
+		if unit > 0 && unit != time.Second {
+			m.scale = unit.Seconds()
+		}
+	}
+}
+
+// WithAdaptivePeriod makes the Monitor report every fast
+// while events are happening, so that user interfaces stay
+// responsive, but only every slow once a period passes in
+// which none do, to save work while the stream is idle. The
+// Interval of each Rate is the length of its period. It
+// overrides WithPeriod. Combined with WithReportOnActivity,
+// the first events after an idle period are reported
+// without waiting for the slow period to end.
+func WithAdaptivePeriod(fast, slow time.Duration) MonitorOption {
+	return func(m *Monitor) {
+		WithPeriod(fast)(m)
+		m.slow = slow
+	}
+}
+
+// WithReportOnActivity makes the Monitor report as soon as
+// events happen after a period in which none did, rather
+// than at the end of the period, so that a user interface
+// can show that a transfer has started without waiting
+// for up to a whole period. The report covers the part of
+// the period which has elapsed, and the next period starts
+// when it is made.
+func WithReportOnActivity() MonitorOption {
+	return func(m *Monitor) { m.wake = make(chan struct{}, 1) }
+}
+
+// WithTickRegions makes the Monitor mark the work it does
+// at the end of each period, including calling the function
+// given to WithFunc or sending on the channel given to
+// WithChannel, as a region named "rate.tick" in execution
+// traces.
+func WithTickRegions() MonitorOption {
+	return func(m *Monitor) { m.trace = true }
+}
+
+// WithFunc makes the Monitor call f in a separate
+// goroutine every period.
+func WithFunc(f func(r Rate)) MonitorOption {
+	return func(m *Monitor) { m.f = f }
+}
+
+// WithChannel makes the Monitor send the rate and total
+// on ch every period. If ch is not ready to receive,
+// the Monitor blocks until it is.
+func WithChannel(ch chan<- Rate) MonitorOption {
+	return WithFunc(func(r Rate) { ch <- r })
+}
+
+// NewMonitor creates a new Monitor. Unless it is given
+// WithFunc or WithChannel, the Monitor does not report
+// its rate, but it still computes statistics about it.
+func NewMonitor(opts ...MonitorOption) *Monitor {
+	ret := newMonitor(opts)
+	ret.running = 1
+	go ret.monitor()
+	return ret
+}
+
+// NewMonitorContext is like NewMonitor, but the Monitor
+// stops reporting once ctx is done, as though Close had
+// been called, so that a Monitor created for a request
+// cannot outlive the request even if it is never closed.
+// Its goroutine exits by the end of the period in which
+// ctx is done, without reporting that period.
+func NewMonitorContext(ctx context.Context, opts ...MonitorOption) *Monitor {
+	ret := newMonitor(opts)
+	ret.done = ctx.Done()
+	ret.running = 1
+	go ret.monitor()
+	return ret
+}
+
+// NewManualMonitor is like NewMonitor, but the Monitor does
+// not start a goroutine of its own; it counts events, but
+// does not report or compute statistics until Run is called.
+// It allows the lifetime of the Monitor to be managed by
+// supervision patterns such as errgroup:
+//
+//	m := rate.NewManualMonitor(rate.WithFunc(report))
+//	g.Go(func() error { return m.Run(ctx) })
+func NewManualMonitor(opts ...MonitorOption) *Monitor {
+	return newMonitor(opts)
+}
+
+var errNotManual = errors.New("rate: Run called on a Monitor which is running or was not created by NewManualMonitor")
+
+// Run reports m's rate every period until ctx is done, in
+// which case it returns ctx.Err(), or until m is closed, in
+// which case it returns nil. Run may only be called once,
+// and only on a Monitor created by NewManualMonitor; it
+// returns an error otherwise.
+func (m *Monitor) Run(ctx context.Context) error {
+	if m.exit == nil || !atomic.CompareAndSwapUint32(&m.running, 0, 1) {
+		return errNotManual
+	}
+	m.done = ctx.Done()
+	m.monitor()
+	return ctx.Err()
 }
 
 // MakeMonitor creates a new Monitor which writes
 // the rate and total to the returned channel every period.
 // If period == 0, the default period of 500ms will
-// be used.
-func MakeMonitor(period time.Duration) (*Monitor, <-chan Rate) {
+// be used. Once the Monitor is closed, and every value
+// has been written, the channel is closed, so that
+// consumers can range over it:
+//
+//	m, ch := rate.MakeMonitor(time.Second)
+//	go func() {
+//		for r := range ch {
+//			log.Printf("%.0f B/s", r.Rate)
+//		}
+//	}()
+//
+// Deprecated: Use NewMonitor with WithPeriod and WithChannel.
+func MakeMonitor(period time.Duration, opts ...MonitorOption) (*Monitor, <-chan Rate) {
 	rch := make(chan Rate, 8)
-	return MakeMonitorFunc(period, func(r Rate) {
-		rch <- r
-	}), rch
+	closeOnStop := func(m *Monitor) { m.stopped = func() { close(rch) } }
+	return NewMonitor(prepend(opts, WithPeriod(period), WithChannel(rch), closeOnStop)...), rch
 }
 
 // MakeMonitorFunc creates a new Monitor which calls f
 // in a separate goroutine every period. If period
 // == 0, the default period of 500ms will be used.
-func MakeMonitorFunc(period time.Duration, f func(r Rate)) *Monitor {
-	if period == 0 {
-		period = defaultPeroid
-	}
+//
+// Deprecated: Use NewMonitor with WithPeriod and WithFunc.
+func MakeMonitorFunc(period time.Duration, f func(r Rate), opts ...MonitorOption) *Monitor {
+	return NewMonitor(prepend(opts, WithPeriod(period), WithFunc(f))...)
+}
+
+// prepend returns a new slice holding first
+// followed by opts.
+func prepend(opts []MonitorOption, first ...MonitorOption) []MonitorOption {
+	return append(first, opts...)
+}
+
+// newMonitor creates a new Monitor without starting
+// its goroutine.
+func newMonitor(opts []MonitorOption) *Monitor {
 	ret := &Monitor{
-		f:      f,
-		period: period,
+		period: defaultPeriod,
 		exit:   make(chan struct{}, 1),
 	}
-	go ret.monitor()
+	for _, o := range opts {
+		o(ret)
+	}
 	return ret
 }
 
 func (m *Monitor) monitor() {
+	if m.stopped != nil {
+		defer m.stopped()
+	}
 	m.t0 = time.Now()
 	for {
 		select {
 		case <-m.exit:
 			return
+		case <-m.done:
+			return
 		default:
 			// Use default and sleep instead of
 			// a time.After case because extra
 			// thread switching under heavy loads
 			// makes a big performance difference.
-			time.Sleep(m.period)
+			m.sleep()
 
 			// In case we missed an exit command
 			// while we were sleeping; this technically
@@ -87,161 +428,348 @@ func (m *Monitor) monitor() {
 			select {
 			case <-m.exit:
 				return
+			case <-m.done:
+				return
 			default:
 			}
 
-			t1 := time.Now()
-			delta := t1.Sub(m.t0)
-			m.t0 = t1
+			var region *trace.Region
+			if m.trace {
+				region = trace.StartRegion(context.Background(), "rate.tick")
+			}
+			n, w := m.n, m.w
+			r, ok := m.tick(time.Now())
+			if ok && m.f != nil && m.report(r) {
+				m.f(r)
+			}
+			if region != nil {
+				region.End()
+			}
+			idle := m.n == n && m.w == w
+			if m.slow != 0 {
+				m.cur = m.period
+				if idle {
+					m.cur = m.slow
+				}
+			}
+			if m.wake != nil && idle {
+				m.sleepUntilActive()
+			}
+		}
+	}
+}
+
+// sleep waits for the end of the period, or
+// for activity after an idle period.
+func (m *Monitor) sleep() {
+	if m.wake == nil {
+		time.Sleep(m.length())
+		return
+	}
+	t := time.NewTimer(m.length())
+	select {
+	case <-t.C:
+	case <-m.wake:
+		t.Stop()
+	}
+}
 
-			nn := atomic.SwapUint64(&m.nn, 0)
-			m.n += nn
+// length returns the length of the current period.
+func (m *Monitor) length() time.Duration {
+	if m.cur != 0 {
+		return m.cur
+	}
+	return m.period
+}
+
+// sleepUntilActive arranges for m to be woken
+// by the next event after an idle period.
+func (m *Monitor) sleepUntilActive() {
+	atomic.StoreUint32(&m.asleep, 1)
+	// Events which happened before m went to
+	// sleep would not have woken it.
+	if atomic.LoadUint64(&m.total) != m.n || atomic.LoadUint64(&m.wasted) != m.w {
+		m.active()
+	}
+}
 
-			rate := float64(nn) / delta.Seconds()
-			m.f(Rate{m.n, rate})
+// active wakes m if it is asleep.
+func (m *Monitor) active() {
+	if atomic.LoadUint32(&m.asleep) == 1 && atomic.CompareAndSwapUint32(&m.asleep, 1, 0) {
+		select {
+		case m.wake <- struct{}{}:
+		default:
 		}
 	}
 }
 
-func (m *Monitor) Add(n uint64) {
-	atomic.AddUint64(&m.nn, n)
+// report returns whether r should be reported,
+// according to m's policy for idle periods.
+func (m *Monitor) report(r Rate) bool {
+	if m.idleEvery == 0 {
+		return true
+	}
+	if r.Rate != 0 || r.WasteRate != 0 {
+		m.idle = 0
+		return true
+	}
+	m.idle++
+	return m.idleEvery > 0 && m.idle%m.idleEvery == 0
 }
 
-// Close stops m from monitoring its rate. If m was
-// created with MakeMonitor, no more values will be
-// written to the channel, and if it was created with
-// MakeMonitorFunc, f will not be called again.
-func (m *Monitor) Close() {
-	// Since m.exit is buffered, the first
-	// value will always be sent. This way,
-	// subsequent calls to Close will never
-	// block.
-	select {
-	case m.exit <- struct{}{}:
-	default:
+// tick ends the current period at t1 and computes
+// the report for it. If ok is false, no report
+// should be made.
+func (m *Monitor) tick(t1 time.Time) (r Rate, ok bool) {
+	// Both readings carry the monotonic clock,
+	// so delta is immune to changes to the wall
+	// clock. It may still be much longer than
+	// m.period if the machine was suspended.
+	delta := t1.Sub(m.t0)
+	m.t0 = t1
+
+	if m.before != nil {
+		m.before(t1)
+	}
+	m.flushLocals()
+	// The totals may have decreased since the last
+	// period if events were reclassified as wasted.
+	total, wasted := atomic.LoadUint64(&m.total), atomic.LoadUint64(&m.wasted)
+	nn, ww := int64(total-m.n), int64(wasted-m.w)
+	m.n, m.w = total, wasted
+
+	m.tagMu.Lock()
+	tags := m.tags
+	m.tags = nil
+	m.tagMu.Unlock()
+	variance := math.Float64frombits(atomic.SwapUint64(&m.variance, 0))
+
+	r, ok = m.rate(nn, ww, delta)
+	r.Time = t1
+	if !ok {
+		return r, false
+	}
+	if variance > 0 {
+		r.RateError = math.Sqrt(variance) / r.Interval.Seconds()
+		if m.scale != 0 {
+			r.RateError *= m.scale
+		}
+	}
+	if len(tags) > 0 {
+		r.Tags = make(map[string]float64, len(tags))
+		for tag, n := range tags {
+			r.Tags[tag] = float64(n) / r.Interval.Seconds()
+			if m.scale != 0 {
+				r.Tags[tag] *= m.scale
+			}
+		}
+	}
+	m.smooth(&r)
+	m.mu.Lock()
+	r.Labels = m.labels
+	m.record(r, nn)
+	m.mu.Unlock()
+	if m.detector != nil {
+		if a, ok := m.detector.Detect(r); ok && m.anomaly != nil {
+			m.anomaly(a)
+		}
+	}
+	return r, true
+}
+
+// rate computes the Rate for a period in which nn
+// events and ww wasted events happened over delta,
+// applying m's gap policy. If ok is false, no rate
+// should be reported.
+func (m *Monitor) rate(nn, ww int64, delta time.Duration) (r Rate, ok bool) {
+	r.Total, r.Wasted = m.n, m.w
+	period := m.length()
+	if delta <= 0 {
+		// This should never happen with a monotonic
+		// clock, but avoid reporting an infinite or
+		// negative rate if it does.
+		delta = period
+	}
+	if delta > 2*period {
+		switch m.gap {
+		case GapDrop:
+			return r, false
+		case GapFlag:
+			r.Gap = delta - period
+			delta = period
+		}
+	}
+	r.Interval = delta
+	r.Rate = float64(nn) / delta.Seconds()
+	r.WasteRate = float64(ww) / delta.Seconds()
+	if m.scale != 0 {
+		r.Rate *= m.scale
+		r.WasteRate *= m.scale
 	}
+	return r, true
 }
 
-// A MonitorReader wraps an io.Reader and monitors the rate
-// at which bytes are read from it. Every period, the average
-// rate at which bytes were read over the preceding period
-// and the total number of bytes read so far are either written
-// to a channel, or passed as the argument to a function.
-type MonitorReader struct {
-	r   io.Reader
-	m   *Monitor
-	err error
+// Add signals that n events have happened. Calling Add
+// on a nil *Monitor is a no-op, so that code can count
+// events unconditionally and leave it to configuration
+// whether they are monitored.
+func (m *Monitor) Add(n uint64) {
+	if m == nil {
+		return
+	}
+	atomic.AddUint64(&m.total, n)
+	if m.wake != nil {
+		m.active()
+	}
 }
 
-// MakeMonitorReader creates a new MonitorReader which writes
-// the rate and total to the returned channel every period.
-// If period == 0, the default period of 500ms will be used.
-func MakeMonitorReader(r io.Reader, period time.Duration) (*MonitorReader, <-chan Rate) {
-	m, rch := MakeMonitor(period)
-	return &MonitorReader{r: r, m: m}, rch
+// AddTagged is like Add, but also attributes the events to
+// tag (for example, the handler which produced them), so that
+// each Rate reported by m includes a breakdown of the rate by
+// tag, in its Tags field. Tags need not be declared in
+// advance. AddTagged is more expensive than Add, and should
+// be used with a small number of distinct tags.
+func (m *Monitor) AddTagged(n uint64, tag string) {
+	if m == nil {
+		return
+	}
+	m.tagMu.Lock()
+	if m.tags == nil {
+		m.tags = make(map[string]uint64)
+	}
+	m.tags[tag] += n
+	m.tagMu.Unlock()
+	m.Add(n)
 }
 
-// MakeMonitorReaderFunc creates a new MonitorReader which calls
-// f in a separate goroutine every period. If period == 0, the
-// default period of 500ms will be used.
-func MakeMonitorReaderFunc(r io.Reader, period time.Duration, f func(r Rate)) *MonitorReader {
-	m := MakeMonitorFunc(period, f)
-	return &MonitorReader{r: r, m: m}
+// Sub corrects for n events which were counted by Add but
+// should not have been; for example, bytes written as part
+// of a transaction which was later rolled back. Like Add,
+// it may be called on a nil *Monitor. The correction is
+// reflected in the next period's rate, which may therefore
+// be negative. The total must not be made negative.
+func (m *Monitor) Sub(n uint64) {
+	if m == nil {
+		return
+	}
+	atomic.AddUint64(&m.total, -n)
 }
 
-func (m *MonitorReader) Read(p []byte) (n int, err error) {
-	if m.err != nil {
-		n, err = 0, m.err
+// AddWasted signals that n wasted events have happened,
+// such as bytes which had to be retransmitted. They are
+// reported separately from the events counted by Add,
+// in the Wasted and WasteRate fields of each Rate.
+func (m *Monitor) AddWasted(n uint64) {
+	if m == nil {
 		return
 	}
-	if m.r == nil {
-		n, err = 0, io.EOF
+	atomic.AddUint64(&m.wasted, n)
+	if m.wake != nil {
+		m.active()
+	}
+}
+
+// Waste reclassifies n of the events already counted
+// by Add as wasted; for example, when an upload fails,
+// the bytes sent before the failure were not progress
+// after all. The change is reflected in the next
+// period's rates, so the rate reported for that
+// period may be negative.
+func (m *Monitor) Waste(n uint64) {
+	if m == nil {
 		return
 	}
+	atomic.AddUint64(&m.wasted, n)
+	m.Sub(n)
+}
 
-	n, err = m.r.Read(p)
-	m.m.Add(uint64(n))
-	return
+// Total returns the total number of events so far.
+func (m *Monitor) Total() uint64 {
+	if m == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&m.total)
 }
 
-// Close closes the reader; all subsequent calls to Read
-// will return io.EOF or any error previously encountered,
-// and the rate will not be reported any more. Additionally,
-// if m's underlying Reader implements the io.ReadCloser
-// interface, its Close method will be called, and its
-// return value will be returned from this method.
+// CurrentRate returns the rate over the most recent
+// period, or 0 if no period has elapsed yet. It is 0
+// for a Monitor in pull mode, which does not compute
+// rates. It need not be given WithFunc or WithChannel:
 //
-// If m's underlying writer implements io.ReadCloser,
-// but it's undesirable for its Close method to be called,
-// wrap it in a ReaderOnly before creating m.
-func (m *MonitorReader) Close() error {
-	m.m.Close()
-	defer func() { m.r = nil }()
-	if rc, ok := m.r.(io.ReadCloser); ok {
-		return rc.Close()
-	}
-	return nil
-}
-
-// A MonitorWriter wraps an io.Writer and monitors the rate
-// at which bytes are written to it, Every period, the average
-// rate at which bytes were written over the preceding period
-// and the total number of bytes written so far are either
-// written to a channel, or passed as the argument to a function.
-type MonitorWriter struct {
-	w   io.Writer
-	m   *Monitor
-	err error
-}
-
-// MakeMonitorWriter creates a new MonitorWriter which writes
-// the rate and total to the returned channel every period.
-// If period == 0, the default period of 500ms will be used.
-func MakeMonitorWriter(w io.Writer, period time.Duration) (*MonitorWriter, <-chan Rate) {
-	m, rch := MakeMonitor(period)
-	return &MonitorWriter{w: w, m: m}, rch
+//	m := rate.NewMonitor()
+//	...
+//	fmt.Printf("%.0f B/s\n", m.CurrentRate())
+func (m *Monitor) CurrentRate() float64 {
+	if m == nil {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.last.Rate
 }
 
-// MakeMonitorWriterFunc creates a new MonitorWriter which calls
-// f in a separate goroutine every period. If period == 0, the
-// default period of 500ms will be used.
-func MakeMonitorWriterFunc(w io.Writer, period time.Duration, f func(r Rate)) *MonitorWriter {
-	m := MakeMonitorFunc(period, f)
-	return &MonitorWriter{w: w, m: m}
+// Snapshot returns the Rate most recently reported by m,
+// except that its Total, Wasted, and Labels are current,
+// so that pull-based consumers, such as status pages, can
+// query m on demand without consuming its channel or
+// registering a function. Before the first period has
+// elapsed, and for a Monitor in pull mode, only the
+// Total, Wasted, and Labels are set.
+func (m *Monitor) Snapshot() Rate {
+	if m == nil {
+		return Rate{}
+	}
+	m.mu.Lock()
+	r := m.last
+	r.Labels = m.labels
+	m.mu.Unlock()
+	r.Total = m.Total()
+	r.Wasted = atomic.LoadUint64(&m.wasted)
+	return r
 }
 
-func (m *MonitorWriter) Write(p []byte) (n int, err error) {
-	if m.err != nil {
-		n, err = 0, m.err
-		return
+// Labels returns the labels attached to m. They
+// must not be modified.
+func (m *Monitor) Labels() Labels {
+	if m == nil {
+		return nil
 	}
-	if m.w == nil {
-		n, err = 0, io.EOF
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.labels
+}
+
+// SetLabels replaces the labels attached to m. The new
+// labels are included in every Rate reported from the
+// end of the current period on.
+func (m *Monitor) SetLabels(labels Labels) {
+	if m == nil {
 		return
 	}
-
-	n, err = m.w.Write(p)
-	m.m.Add(uint64(n))
-	return
+	labels = labels.clone()
+	m.mu.Lock()
+	m.labels = labels
+	m.mu.Unlock()
 }
 
-// Close closes the writer; all subsequent calls to Write
-// will return io.EOF or any error previously encountered,
-// and the rate will not be reported any more. Additionally,
-// if m's underlying Writer implements the io.WriteCloser
-// interface, its Close method will be called, and its
-// return value will be returned from this method.
-//
-// If m's underlying writer implements io.WriteCloser,
-// but it's undesirable for its Close method to be called,
-// wrap it in a WriterOnly before creating m.
-func (m *MonitorWriter) Close() error {
-	m.m.Close()
-	defer func() { m.w = nil }()
-	if wc, ok := m.w.(io.WriteCloser); ok {
-		return wc.Close()
-	}
-	return nil
+// Close stops m from monitoring its rate. No more values
+// will be written to the channel given to WithChannel,
+// and the function given to WithFunc will not be called
+// again; the channel returned by MakeMonitor is closed
+// once the last value has been written to it. Calling
+// Close on a nil *Monitor is a no-op.
+func (m *Monitor) Close() {
+	if m == nil {
+		return
+	}
+	// Since m.exit is buffered, the first
+	// value will always be sent. This way,
+	// subsequent calls to Close will never
+	// block.
+	select {
+	case m.exit <- struct{}{}:
+	default:
+	}
 }
 
 // ReaderOnly allows a type which implements
@@ -0,0 +1,123 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// A Part is a contiguous range of a transfer which is
+// split by Parallel.
+type Part struct {
+	// Index is the index of the part; the part with
+	// index i starts at byte i * the part size.
+	Index        int
+	Offset, Size int64
+
+	opts []Option
+	ctx  context.Context // the context of the transfer
+}
+
+// Reader wraps r so that the bytes read from it are limited
+// and counted along with those of the transfer's other parts.
+// Reads stop waiting for the limit once the transfer's
+// context is done.
+func (p Part) Reader(r io.Reader) *Reader {
+	return NewReader(r, p.options()...)
+}
+
+// Writer wraps w so that the bytes written to it are limited
+// and counted along with those of the transfer's other parts.
+// Writes stop waiting for the limit once the transfer's
+// context is done.
+func (p Part) Writer(w io.Writer) *Writer {
+	return NewWriter(w, p.options()...)
+}
+
+func (p Part) options() []Option {
+	if p.ctx == nil {
+		return p.opts
+	}
+	return append(p.opts[:len(p.opts):len(p.opts)], WithContext(p.ctx))
+}
+
+// Parallel splits a transfer of size bytes into parts of
+// partSize bytes (the last may be shorter), and transfers
+// them using up to n concurrent calls to f. f transfers
+// a single part, for example with an HTTP range request,
+// and must read or write its bytes through p.Reader or
+// p.Writer, so that the rate and total of the transfer as
+// a whole are limited and monitored according to opts:
+//
+//	m := rate.NewMonitor(rate.WithFunc(status.Update))
+//	err := rate.Parallel(ctx, size, 8<<20, 4, func(ctx context.Context, p rate.Part) error {
+//		body, err := fetchRange(ctx, url, p.Offset, p.Size)
+//		if err != nil {
+//			return err
+//		}
+//		defer body.Close()
+//		_, err = io.Copy(bufs[p.Index], p.Reader(body))
+//		return err
+//	}, rate.WithLimit(10<<20), rate.WithMonitor(m))
+//
+// If any call to f returns an error, the context passed to
+// the others is canceled, no more parts are started, and
+// Parallel returns the first error once the calls in progress
+// have returned. Otherwise, Parallel returns nil once every
+// part has been transferred.
+func Parallel(ctx context.Context, size, partSize int64, n int, f func(ctx context.Context, p Part) error, opts ...Option) error {
+	if partSize <= 0 {
+		partSize = size
+	}
+	if n <= 0 {
+		n = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	parts := make(chan Part)
+	go func() {
+		defer close(parts)
+		for i, off := 0, int64(0); off < size; i, off = i+1, off+partSize {
+			p := Part{Index: i, Offset: off, Size: partSize, opts: opts, ctx: ctx}
+			if size-off < partSize {
+				p.Size = size - off
+			}
+			select {
+			case parts <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var first error
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range parts {
+				if err := f(ctx, p); err != nil {
+					once.Do(func() {
+						first = err
+						cancel()
+					})
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if first == nil {
+		// The parent context may have been canceled
+		// before all of the parts were started.
+		first = ctx.Err()
+	}
+	return first
+}
@@ -0,0 +1,111 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseBytes parses a quantity of bytes, such as "2MiB",
+// "1.5 GB", or "4096". Decimal (SI) prefixes (k, M, G, T,
+// P, and E) are powers of 1000, and binary (IEC) prefixes
+// (Ki, Mi, and so on) are powers of 1024; K is accepted for
+// k. The unit, B, may be omitted. The result is rounded to
+// the nearest byte.
+func ParseBytes(s string) (uint64, error) {
+	v, bits, err := parseUnits(s)
+	if err != nil || bits || v >= Inf {
+		return 0, fmt.Errorf("rate: invalid quantity of bytes %q", s)
+	}
+	return uint64(v + 0.5), nil
+}
+
+// ParseRate parses a rate in bytes or bits per second, and
+// returns it in bytes per second, so that CLIs can accept
+// limits as flags:
+//
+//	bps, err := rate.ParseRate(*limit)
+//	...
+//	r = rate.NewLimitReader(r, bps)
+//
+// A rate is a quantity, as accepted by ParseBytes, followed
+// by "/s" or "ps", such as "10MB/s", "512KiBps", or "1.5 GB/s";
+// the suffix may be omitted. Quantities in bits use the unit
+// b or bit rather than B, such as "10Mbps" or "100 Mbit/s".
+// "inf" and "unlimited" are parsed as Inf. The result is
+// rounded to the nearest byte per second, except that a
+// rate which is not 0, such as "1bps", is never rounded
+// to 0, which would block forever.
+func ParseRate(s string) (uint64, error) {
+	t := strings.TrimSpace(s)
+	switch strings.ToLower(t) {
+	case "inf", "unlimited":
+		return Inf, nil
+	}
+	if strings.HasSuffix(t, "/s") {
+		t = strings.TrimSuffix(t, "/s")
+	} else if strings.HasSuffix(t, "ps") {
+		t = strings.TrimSuffix(t, "ps")
+	}
+	v, bits, err := parseUnits(t)
+	if bits {
+		v /= 8
+	}
+	if err != nil || v >= Inf {
+		return 0, fmt.Errorf("rate: invalid rate %q", s)
+	}
+	if v > 0 && v < 1 {
+		return 1, nil
+	}
+	return uint64(v + 0.5), nil
+}
+
+// parseUnits parses a quantity of bytes or bits, and
+// returns it in the units given, and whether they are bits.
+func parseUnits(s string) (v float64, bits bool, err error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+	v, err = strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, false, err
+	}
+	unit := strings.TrimSpace(s[i:])
+
+	switch {
+	case strings.HasSuffix(unit, "bit"):
+		unit, bits = strings.TrimSuffix(unit, "bit"), true
+	case strings.HasSuffix(unit, "b"):
+		unit, bits = strings.TrimSuffix(unit, "b"), true
+	case strings.HasSuffix(unit, "B"):
+		unit = strings.TrimSuffix(unit, "B")
+	}
+	base := 1000.0
+	if strings.HasSuffix(unit, "i") {
+		unit, base = strings.TrimSuffix(unit, "i"), 1024
+		if unit == "" {
+			return 0, false, strconv.ErrSyntax
+		}
+	}
+	if unit != "" {
+		p := strings.Index("kMGTPE", unit)
+		if unit == "K" {
+			p = 0
+		}
+		if len(unit) != 1 || p < 0 {
+			return 0, false, strconv.ErrSyntax
+		}
+		for ; p >= 0; p-- {
+			v *= base
+		}
+	}
+	return v, bits, nil
+}
@@ -0,0 +1,44 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import "testing"
+
+func TestParseRate(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		want uint64
+	}{
+		{"0", 0},
+		{"1", 1},
+		{"10MB/s", 10000000},
+		{"512KiBps", 512 << 10},
+		{"1.5 GB/s", 1500000000},
+		{"10Mbps", 1250000},
+		{"100 Mbit/s", 12500000},
+		{"8bps", 1},
+		{"12 bit/s", 2},
+		// Rates below 1 byte per second are not rounded
+		// to 0, which would block forever.
+		{"1bps", 1},
+		{"3 bit/s", 1},
+		{"0.1", 1},
+		{"0bps", 0},
+		{"inf", Inf},
+		{"Unlimited", Inf},
+	} {
+		got, err := ParseRate(c.s)
+		if err != nil {
+			t.Errorf("ParseRate(%q): unexpected error: %v", c.s, err)
+		} else if got != c.want {
+			t.Errorf("ParseRate(%q) = %v; want %v", c.s, got, c.want)
+		}
+	}
+	for _, s := range []string{"", "MB/s", "-1", "10XB/s", "10ib"} {
+		if _, err := ParseRate(s); err == nil {
+			t.Errorf("ParseRate(%q): expected error", s)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"fmt"
+	"time"
+)
+
+// A CeilingError records that a stream exceeded the ceiling
+// on its rate set with WithCeiling.
+type CeilingError struct {
+	// Ceiling is the rate, in bytes per second, which
+	// was exceeded on average over Window.
+	Ceiling uint64
+	Window  time.Duration
+	// Time is the time at which the ceiling was exceeded.
+	Time time.Time
+}
+
+func (e *CeilingError) Error() string {
+	return fmt.Sprintf("rate: exceeded ceiling of %d B/s over %v", e.Ceiling, e.Window)
+}
+
+// ceiling measures a stream's rate against a ceiling,
+// using a leaky bucket which holds a window's worth
+// of bytes at the ceiling, and drains at the ceiling.
+type ceiling struct {
+	bps    uint64
+	window time.Duration
+	f      func(e *CeilingError)
+
+	level float64
+	last  time.Time
+	over  bool
+}
+
+// WithCeiling makes the Reader or Writer police its rate
+// rather than limit it: bytes are never delayed, but if more
+// than bps bytes per second are transferred on average over
+// any window of length window, the stream is in violation.
+// This is useful for enforcing contracts (such as "clients
+// must not send more than 1MB/s") where shaping the traffic
+// would hide the violation.
+//
+// If f is nil, the read or write in which the violation
+// happens returns a *CeilingError, as do all subsequent
+// reads or writes. Otherwise, f is called when the stream
+// goes into violation, and the stream continues; f is not
+// called again until the rate has fallen below the ceiling
+// and exceeded it again.
+func WithCeiling(bps uint64, window time.Duration, f func(e *CeilingError)) Option {
+	return func(s *stream) {
+		s.ceil = &ceiling{bps: bps, window: window, f: f}
+	}
+}
+
+// observe records that n bytes were transferred,
+// and returns an error if this exceeds the ceiling.
+func (c *ceiling) observe(n int) *CeilingError {
+	now := time.Now()
+	if !c.last.IsZero() {
+		c.level -= float64(c.bps) * now.Sub(c.last).Seconds()
+		if c.level < 0 {
+			c.level = 0
+		}
+	}
+	c.last = now
+	c.level += float64(n)
+	if c.level <= float64(c.bps)*c.window.Seconds() {
+		c.over = false
+		return nil
+	}
+	if c.over {
+		return nil
+	}
+	c.over = true
+	return &CeilingError{Ceiling: c.bps, Window: c.window, Time: now}
+}
+
+// police checks the n bytes just transferred
+// against s's ceiling, if it has one.
+func (s *stream) police(n int) error {
+	if s.ceil == nil || n <= 0 {
+		return nil
+	}
+	e := s.ceil.observe(n)
+	switch {
+	case e == nil:
+		return nil
+	case s.ceil.f != nil:
+		s.ceil.f(e)
+		return nil
+	}
+	s.err = e
+	return e
+}
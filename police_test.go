@@ -0,0 +1,52 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// readerOnly hides the io.WriterTo method of
+// a Reader, so that io.Copy uses ReadFrom.
+type readerOnly struct{ io.Reader }
+
+// TestCeilingCopy tests that the ceiling is enforced when
+// io.Copy uses the ReadFrom or WriteTo fast paths, which
+// copy through a peer which implements io.ReaderFrom.
+func TestCeilingCopy(t *testing.T) {
+	f, err := ioutil.TempFile("", "rate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	data := make([]byte, 1<<20)
+
+	w := NewWriter(f, WithCeiling(10, time.Second, nil))
+	_, err = io.Copy(w, readerOnly{bytes.NewReader(data)})
+	if _, ok := err.(*CeilingError); !ok {
+		t.Errorf("io.Copy to Writer: got error %v; want *CeilingError", err)
+	}
+
+	r := NewReader(bytes.NewReader(data), WithCeiling(10, time.Second, nil))
+	_, err = io.Copy(f, r)
+	if _, ok := err.(*CeilingError); !ok {
+		t.Errorf("io.Copy from Reader: got error %v; want *CeilingError", err)
+	}
+
+	var violations int
+	w = NewWriter(f, WithCeiling(10, time.Second, func(*CeilingError) { violations++ }))
+	if _, err := io.Copy(w, readerOnly{bytes.NewReader(data)}); err != nil {
+		t.Errorf("io.Copy with callback: unexpected error: %v", err)
+	}
+	if violations != 1 {
+		t.Errorf("got %d violations; want 1", violations)
+	}
+}
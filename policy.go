@@ -0,0 +1,132 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Policies holds limits declared for sets of labels, such as
+// "tenant=acme, class=bulk", and hands out Limiters for
+// streams by their labels. A stream is subject to every
+// policy whose labels are a subset of its own; the streams
+// subject to a policy share its limit. Policies may be
+// changed at any time, and changes apply at once to every
+// stream whose Limiter was obtained from the Policies:
+//
+//	ps := rate.NewPolicies()
+//	ps.Set(rate.Labels{"tenant": "acme"}, 10<<20)
+//	ps.Set(rate.Labels{"tenant": "acme", "class": "bulk"}, 2<<20)
+//	w := rate.NewWriter(c, rate.WithLimiter(ps.Limiter(rate.Labels{
+//		"tenant": "acme",
+//		"class":  "bulk",
+//		"route":  "/upload",
+//	})))
+//	...
+//	ps.Set(rate.Labels{"tenant": "acme", "class": "bulk"}, 4<<20) // w may now use 4MB/s
+type Policies struct {
+	opts []LimitOption
+
+	mu    sync.RWMutex
+	rules map[string]*policy
+}
+
+type policy struct {
+	labels Labels
+	p      *Pacer
+}
+
+// NewPolicies returns a new, empty, set of Policies. The
+// limit of each policy is enforced by a Pacer created with
+// opts.
+func NewPolicies(opts ...LimitOption) *Policies {
+	return &Policies{opts: opts, rules: make(map[string]*policy)}
+}
+
+// Set sets the limit of the policy for labels to bps units
+// per second, creating the policy if it does not exist. An
+// empty set of labels matches every stream. labels must not
+// be modified afterwards.
+func (ps *Policies) Set(labels Labels, bps uint64) {
+	k := labels.key()
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if r, ok := ps.rules[k]; ok {
+		r.p.SetRate(bps)
+		return
+	}
+	ps.rules[k] = &policy{labels: labels, p: NewPacer(bps, ps.opts...)}
+}
+
+// Delete deletes the policy for labels, lifting its
+// limit from the streams which were subject to it.
+func (ps *Policies) Delete(labels Labels) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if r, ok := ps.rules[labels.key()]; ok {
+		delete(ps.rules, labels.key())
+		// Wake anything blocked by a rate of 0.
+		r.p.SetRate(Inf)
+	}
+}
+
+// Limiter returns a Limiter for a stream with the given
+// labels, which enforces every policy which matches them,
+// including policies set after it is returned.
+func (ps *Policies) Limiter(labels Labels) Limiter {
+	return policyLimiter{ps: ps, labels: labels}
+}
+
+// match returns a Limiter which enforces the
+// policies which currently match labels.
+func (ps *Policies) match(labels Labels) *composite {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	c := new(composite)
+	keys := make([]string, 0, len(ps.rules))
+	for k, r := range ps.rules {
+		if r.labels.subsetOf(labels) {
+			keys = append(keys, k)
+		}
+	}
+	// Always list the policies in the same order,
+	// so that the error reported when several fail
+	// at once is consistent.
+	sort.Strings(keys)
+	for _, k := range keys {
+		c.ls = append(c.ls, ps.rules[k].p)
+	}
+	return c
+}
+
+// subsetOf returns whether every label in
+// l has the same value in m.
+func (l Labels) subsetOf(m Labels) bool {
+	for k, v := range l {
+		if mv, ok := m[k]; !ok || mv != v {
+			return false
+		}
+	}
+	return true
+}
+
+type policyLimiter struct {
+	ps     *Policies
+	labels Labels
+}
+
+func (l policyLimiter) WaitN(ctx context.Context, n int) error {
+	return l.ps.match(l.labels).WaitN(ctx, n)
+}
+
+func (l policyLimiter) take(ctx context.Context, max int) (int, error) {
+	return l.ps.match(l.labels).take(ctx, max)
+}
+
+func (l policyLimiter) refund(n int) {
+	l.ps.match(l.labels).refund(n)
+}
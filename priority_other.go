@@ -0,0 +1,12 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package rate
+
+func setPriority(fd uintptr, prio int) error {
+	return errSockoptUnsupported
+}
@@ -0,0 +1,88 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"io"
+	"time"
+)
+
+// A ProbeResult describes the outcome of measuring
+// the capacity of a path with Probe.
+type ProbeResult struct {
+	// N is the number of bytes copied, over Elapsed.
+	N       uint64
+	Elapsed time.Duration
+	// Rate is the capacity of the path, in bytes per
+	// second, as measured over the latter half of the
+	// probe, after any slow start.
+	Rate float64
+}
+
+// probeChunk is the size of the writes made by Probe.
+const probeChunk = 32 << 10
+
+// Probe measures the unthrottled capacity of the path from r
+// to w by copying from r to w as fast as possible until max
+// bytes have been copied or d has elapsed, whichever comes
+// first. If max == 0, only d bounds the probe. The bytes
+// copied are consumed, so r should usually supply filler
+// rather than real data, and w should discard it at the far
+// end of the path.
+//
+// Since transports such as TCP ramp up gradually, the rate
+// is measured over the second half of the probe only. A probe
+// which ends before a second chunk of 32KB has been copied
+// reports the rate over its whole duration.
+//
+// If the copy fails, Probe returns the measurement made so
+// far along with the error.
+func Probe(w io.Writer, r io.Reader, max uint64, d time.Duration) (p ProbeResult, err error) {
+	buf := make([]byte, probeChunk)
+	start := time.Now()
+	// The time and count at which the second
+	// half of the probe began.
+	var mid time.Time
+	var nmid uint64
+	for {
+		now := time.Now()
+		p.Elapsed = now.Sub(start)
+		if mid.IsZero() && (p.Elapsed >= d/2 || max > 0 && p.N >= max/2) {
+			mid, nmid = now, p.N
+		}
+		if p.Elapsed >= d || max > 0 && p.N >= max {
+			break
+		}
+		b := buf
+		if max > 0 && max-p.N < uint64(len(b)) {
+			b = b[:max-p.N]
+		}
+		n, rerr := r.Read(b)
+		if n > 0 {
+			var nn int
+			nn, err = w.Write(b[:n])
+			p.N += uint64(nn)
+			if err != nil {
+				break
+			}
+		}
+		if rerr == io.EOF {
+			p.Elapsed = time.Now().Sub(start)
+			break
+		}
+		if rerr != nil {
+			err = rerr
+			break
+		}
+	}
+
+	end := start.Add(p.Elapsed)
+	if !mid.IsZero() && p.N-nmid >= probeChunk && end.After(mid) {
+		p.Rate = float64(p.N-nmid) / end.Sub(mid).Seconds()
+	} else if p.Elapsed > 0 {
+		p.Rate = float64(p.N) / p.Elapsed.Seconds()
+	}
+	return
+}
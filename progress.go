@@ -0,0 +1,68 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// WithExpected makes the Monitor track its progress
+// towards a total of n events, such as the size of a
+// file being transferred, and report it in the Expected,
+// Progress, and ETA fields of each Rate, so that a
+// Monitor can serve as a ready-made progress tracker:
+//
+//	m := rate.NewMonitor(rate.WithExpected(size), rate.WithFunc(func(r rate.Rate) {
+//		fmt.Printf("%3.0f%% ETA %v\n", 100*r.Progress, r.ETA)
+//	}))
+//
+// The ETA is estimated from an exponentially weighted
+// moving average of the rate, with the time constant given
+// to WithEWMA, or of five seconds otherwise. If the total
+// only becomes known later, use SetExpected.
+func WithExpected(n uint64) MonitorOption {
+	return func(m *Monitor) { m.expected = n }
+}
+
+// SetExpected sets the total number of events which m
+// expects, as WithExpected does, from the end of the
+// current period on. If n is 0, m stops tracking its
+// progress.
+func (m *Monitor) SetExpected(n uint64) {
+	if m == nil {
+		return
+	}
+	atomic.StoreUint64(&m.expected, n)
+}
+
+// progress sets the progress of r towards expected
+// events, given the smoothed rate.
+func (m *Monitor) progress(r *Rate, expected uint64, smoothed float64) {
+	if m.scale != 0 {
+		// The rate is in events per m.scale seconds.
+		smoothed /= m.scale
+	}
+	setProgress(r, expected, smoothed)
+}
+
+// setProgress sets the Expected, Progress, and ETA fields
+// of r, given a smoothed rate in events per second.
+func setProgress(r *Rate, expected uint64, bps float64) {
+	r.Expected = expected
+	if r.Total >= expected {
+		r.Progress = 1
+		return
+	}
+	r.Progress = float64(r.Total) / float64(expected)
+	r.ETA = -1
+	if bps <= 0 {
+		return
+	}
+	if secs := float64(expected-r.Total) / bps; secs < float64(math.MaxInt64/time.Second) {
+		r.ETA = time.Duration(secs * float64(time.Second))
+	}
+}
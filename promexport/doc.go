@@ -0,0 +1,7 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package promexport exports the rates reported by
+// monitors from package rate to Prometheus.
+package promexport
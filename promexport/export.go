@@ -0,0 +1,144 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package promexport
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/joshlf/rate"
+)
+
+// An Exporter exports the totals and rates of Monitors to
+// Prometheus, which scrapes them from its ServeHTTP method.
+// The values are read from the Monitors when they are
+// scraped (see rate.Monitor.Snapshot), so no goroutine is
+// needed to copy them into metrics:
+//
+//	e := promexport.NewExporter()
+//	e.Register("upload_bytes", m, rate.Labels{"tenant": tenant})
+//	http.Handle("/metrics", e)
+//
+// Each Monitor is exported as two series, as by a Pusher:
+// name_total, a counter holding its total, and name_rate, a
+// gauge holding its rate over the most recent period. Its
+// series carry the labels it was registered with, as well
+// as those it carries itself (see rate.WithLabels); where
+// they conflict, the labels it was registered with win.
+// Label names must be valid Prometheus label names.
+//
+// An Exporter is safe for concurrent use.
+type Exporter struct {
+	mu      sync.Mutex
+	entries map[string][]entry // by metric name
+}
+
+type entry struct {
+	m      *rate.Monitor
+	labels rate.Labels
+}
+
+// NewExporter returns a new Exporter which
+// exports no Monitors.
+func NewExporter() *Exporter {
+	return &Exporter{entries: make(map[string][]entry)}
+}
+
+// Register exports m under the metric name, with the given
+// labels. Several Monitors may be registered under the same
+// name, with different labels. Registering a Monitor under
+// a name and labels which are already registered replaces
+// the Monitor registered before.
+func (e *Exporter) Register(name string, m *rate.Monitor, labels rate.Labels) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	es := e.entries[name]
+	for i := range es {
+		if equal(es[i].labels, labels) {
+			es[i].m = m
+			return
+		}
+	}
+	e.entries[name] = append(es, entry{m: m, labels: clone(labels)})
+}
+
+// Unregister stops exporting the Monitor registered under
+// name with the given labels. It should be called when the
+// Monitor is no longer used.
+func (e *Exporter) Unregister(name string, labels rate.Labels) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	es := e.entries[name]
+	for i := range es {
+		if equal(es[i].labels, labels) {
+			es = append(es[:i:i], es[i+1:]...)
+			break
+		}
+	}
+	if len(es) == 0 {
+		delete(e.entries, name)
+	} else {
+		e.entries[name] = es
+	}
+}
+
+// ServeHTTP serves the exported Monitors in the
+// Prometheus text exposition format.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	e.mu.Lock()
+	names := make([]string, 0, len(e.entries))
+	for name := range e.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		es := e.entries[name]
+		rs := make([]rate.Rate, len(es))
+		for i, en := range es {
+			rs[i] = en.m.Snapshot()
+			rs[i].Labels = merge(rs[i].Labels, en.labels)
+		}
+		writeSeries(&buf, name, rs)
+	}
+	e.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	buf.WriteTo(w)
+}
+
+// merge returns the labels of both a and b,
+// preferring b's where they conflict.
+func merge(a, b rate.Labels) rate.Labels {
+	if len(a) == 0 {
+		return b
+	}
+	l := clone(a)
+	for k, v := range b {
+		l[k] = v
+	}
+	return l
+}
+
+func clone(l rate.Labels) rate.Labels {
+	c := make(rate.Labels, len(l))
+	for k, v := range l {
+		c[k] = v
+	}
+	return c
+}
+
+func equal(a, b rate.Labels) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
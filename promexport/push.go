@@ -0,0 +1,221 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package promexport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/joshlf/rate"
+)
+
+// A Pusher pushes the rates reported by a Monitor to a
+// Prometheus Pushgateway, so that the metrics of batch
+// jobs which finish before they can be scraped are not
+// lost. Its Update method should be passed as the function
+// to rate.MakeMonitorFunc or one of its variants:
+//
+//	p := promexport.NewPusher("http://pushgateway:9091", "backup", "backup_bytes")
+//	r := rate.MakeMonitorReaderFunc(f, 0, p.Update)
+//	...
+//	r.Close()
+//	if err := p.Close(); err != nil {
+//		...
+//	}
+//
+// Each sample is exported as two series: name_total, a
+// counter holding the total, and name_rate, a gauge holding
+// the rate. The labels the Monitor carries (see
+// rate.WithLabels) are attached to both series, so that
+// rates can be broken down by tenant, route, and so on;
+// their names must be valid Prometheus label names.
+//
+// The fields of a Pusher should not be modified once
+// Update has been called.
+type Pusher struct {
+	// Client is the client used to push. If it is nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+	// Grouping holds additional labels identifying the
+	// group to push to, beyond the job label.
+	Grouping map[string]string
+
+	url, job, name string
+
+	// pushMu serializes pushes so that an older
+	// sample never overwrites a newer one.
+	pushMu sync.Mutex
+
+	mu      sync.Mutex
+	last    rate.Rate
+	pending bool
+	err     error
+	wake    chan struct{}
+	done    chan struct{}
+	started bool
+	closed  bool
+}
+
+// NewPusher creates a new Pusher which pushes to the
+// Pushgateway at url, under the given job, using metric
+// names derived from name.
+func NewPusher(url, job, name string) *Pusher {
+	return &Pusher{
+		url:  strings.TrimSuffix(url, "/"),
+		job:  job,
+		name: name,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+}
+
+// Update records r and pushes it in the background.
+// If a previous push is still in progress, only the
+// most recent sample is pushed once it completes.
+func (p *Pusher) Update(r rate.Rate) {
+	p.mu.Lock()
+	if !p.started {
+		p.started = true
+		go p.pusher()
+	}
+	p.last = r
+	p.pending = true
+	p.mu.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Pusher) pusher() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-p.wake:
+			p.flush()
+		}
+	}
+}
+
+// flush pushes the last recorded sample, if it has
+// not already been pushed.
+func (p *Pusher) flush() {
+	p.pushMu.Lock()
+	defer p.pushMu.Unlock()
+
+	p.mu.Lock()
+	r, pending := p.last, p.pending
+	p.pending = false
+	p.mu.Unlock()
+	if !pending {
+		return
+	}
+	if err := p.push(r); err != nil {
+		p.mu.Lock()
+		p.err = err
+		p.mu.Unlock()
+	}
+}
+
+// Close stops pushing in the background, synchronously
+// pushes the last sample passed to Update if it has not
+// been pushed yet, and returns the most recent error
+// encountered while pushing, if any.
+func (p *Pusher) Close() error {
+	p.mu.Lock()
+	if p.started && !p.closed {
+		close(p.done)
+	}
+	p.started, p.closed = true, true
+	p.mu.Unlock()
+	p.flush()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *Pusher) push(r rate.Rate) error {
+	var buf bytes.Buffer
+	writeSeries(&buf, p.name, []rate.Rate{r})
+
+	req, err := http.NewRequest("PUT", p.groupURL(), &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	c := p.Client
+	if c == nil {
+		c = http.DefaultClient
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("promexport: push to %s: unexpected status %s", p.url, resp.Status)
+	}
+	return nil
+}
+
+func (p *Pusher) groupURL() string {
+	u := p.url + "/metrics/job/" + url.PathEscape(p.job)
+	keys := make([]string, 0, len(p.Grouping))
+	for k := range p.Grouping {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		u += "/" + url.PathEscape(k) + "/" + url.PathEscape(p.Grouping[k])
+	}
+	return u
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats l in the Prometheus text format,
+// or returns the empty string if l is empty.
+func labels(l rate.Labels) string {
+	if len(l) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, k, labelEscaper.Replace(l[k]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// writeSeries writes rs in the Prometheus text format, as
+// the series of the metrics name_total and name_rate.
+func writeSeries(w io.Writer, name string, rs []rate.Rate) {
+	fmt.Fprintf(w, "# TYPE %s_total counter\n", name)
+	for _, r := range rs {
+		fmt.Fprintf(w, "%s_total%s %d\n", name, labels(r.Labels), r.Total)
+	}
+	fmt.Fprintf(w, "# TYPE %s_rate gauge\n", name)
+	for _, r := range rs {
+		fmt.Fprintf(w, "%s_rate%s %g\n", name, labels(r.Labels), r.Rate)
+	}
+}
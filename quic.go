@@ -0,0 +1,86 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"io"
+	"time"
+)
+
+// A ReceiveStream is the read side of a stream with
+// deadlines. QUIC receive streams (such as quic-go's
+// ReceiveStream) satisfy it.
+type ReceiveStream interface {
+	io.Reader
+	SetReadDeadline(t time.Time) error
+}
+
+// A SendStream is the write side of a stream with
+// deadlines. QUIC send streams (such as quic-go's
+// SendStream) satisfy it.
+type SendStream interface {
+	io.WriteCloser
+	SetWriteDeadline(t time.Time) error
+}
+
+// A Stream is a bidirectional stream with deadlines.
+// QUIC streams (such as quic-go's Stream) satisfy it,
+// as does net.Conn.
+type Stream interface {
+	io.ReadWriteCloser
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetDeadline(t time.Time) error
+}
+
+// WrapReceiveStream returns a ReceiveStream which reads from
+// s through a Reader configured with opts, so that it may be
+// limited and monitored like any other Reader. Methods of s
+// which are not part of the ReceiveStream interface, such as
+// quic-go's CancelRead, should be called on s directly.
+func WrapReceiveStream(s ReceiveStream, opts ...Option) ReceiveStream {
+	return &receiveStream{s, NewReader(s, opts...)}
+}
+
+// WrapSendStream returns a SendStream which writes to s through
+// a Writer configured with opts, so that it may be limited and
+// monitored like any other Writer. Methods of s which are not
+// part of the SendStream interface, such as quic-go's
+// CancelWrite, should be called on s directly.
+func WrapSendStream(s SendStream, opts ...Option) SendStream {
+	return &sendStream{s, NewWriter(WriterOnly{s}, opts...)}
+}
+
+// WrapStream returns a Stream which reads from s through a
+// Reader configured with readOpts, and writes to s through
+// a Writer configured with writeOpts. Methods of s which are
+// not part of the Stream interface should be called on s
+// directly.
+func WrapStream(s Stream, readOpts, writeOpts []Option) Stream {
+	return &bidiStream{s, NewReader(ReaderOnly{s}, readOpts...), NewWriter(WriterOnly{s}, writeOpts...)}
+}
+
+type receiveStream struct {
+	ReceiveStream
+	r *Reader
+}
+
+func (s *receiveStream) Read(p []byte) (int, error) { return s.r.Read(p) }
+
+type sendStream struct {
+	SendStream
+	w *Writer
+}
+
+func (s *sendStream) Write(p []byte) (int, error) { return s.w.Write(p) }
+
+type bidiStream struct {
+	Stream
+	r *Reader
+	w *Writer
+}
+
+func (s *bidiStream) Read(p []byte) (int, error)  { return s.r.Read(p) }
+func (s *bidiStream) Write(p []byte) (int, error) { return s.w.Write(p) }
@@ -0,0 +1,129 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate_test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/joshlf/rate"
+	"github.com/joshlf/rate/ratetest"
+)
+
+const conformBPS = 10000
+
+// TestConform runs the conformance tests of package
+// ratetest against each of the modes of Pacer.
+func TestConform(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		bps   uint64 // the rate of the Pacer under test
+		opts  func(c rate.Clock) []rate.LimitOption
+		burst int64
+	}{
+		{
+			// A quantum's worth on either side of
+			// the boundary between quanta.
+			name:  "quantum",
+			bps:   conformBPS,
+			burst: 2 * conformBPS / 10,
+		},
+		{
+			name: "strict",
+			bps:  conformBPS,
+			opts: func(rate.Clock) []rate.LimitOption {
+				return []rate.LimitOption{rate.WithStrictPacing()}
+			},
+			burst: conformBPS / 10,
+		},
+		{
+			name: "burst",
+			bps:  conformBPS,
+			opts: func(rate.Clock) []rate.LimitOption {
+				return []rate.LimitOption{rate.WithBurst(conformBPS / 5)}
+			},
+			burst: conformBPS / 5,
+		},
+		{
+			name: "accumulate",
+			bps:  conformBPS,
+			opts: func(rate.Clock) []rate.LimitOption {
+				return []rate.LimitOption{rate.WithIdlePolicy(rate.IdleAccumulate, conformBPS/2)}
+			},
+			burst: conformBPS/2 + 2*conformBPS/10,
+		},
+		{
+			// The parent is the tighter limit.
+			name: "parent",
+			bps:  2 * conformBPS,
+			opts: func(c rate.Clock) []rate.LimitOption {
+				return []rate.LimitOption{rate.WithParent(rate.NewPacer(conformBPS, rate.WithClock(c)))}
+			},
+			burst: 2 * conformBPS / 10,
+		},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			ratetest.Conform(t, func(w io.Writer, clock rate.Clock) io.Writer {
+				opts := []rate.LimitOption{rate.WithClock(clock)}
+				if c.opts != nil {
+					opts = append(opts, c.opts(clock)...)
+				}
+				return rate.NewWriter(w, rate.WithLimiter(rate.NewPacer(c.bps, opts...)))
+			}, conformBPS, c.burst, 0.05)
+		})
+	}
+}
+
+// TestChildren tests that the children of a Pacer
+// together never exceed its rate, even when their own
+// rates would allow more, and that each keeps to its own.
+func TestChildren(t *testing.T) {
+	const (
+		parentBPS = 10000
+		childBPS  = 6000
+		chunk     = 500
+	)
+	clock := ratetest.NewClock(time.Unix(0, 0))
+	parent := rate.NewPacer(parentBPS, rate.WithClock(clock))
+	total := ratetest.NewRecorderClock(clock)
+	var (
+		recs    []*ratetest.Recorder
+		writers []io.Writer
+	)
+	for _, p := range []*rate.Pacer{
+		parent.Child(childBPS, rate.WithClock(clock)),
+		parent.Child(childBPS, rate.WithClock(clock)),
+		parent.Child(2*parentBPS, rate.WithClock(clock)).Child(childBPS, rate.WithClock(clock)),
+	} {
+		r := ratetest.NewRecorderClock(clock)
+		recs = append(recs, r)
+		writers = append(writers, rate.NewWriter(io.MultiWriter(r, total), rate.WithLimiter(p)))
+	}
+
+	buf := make([]byte, chunk)
+	for start := clock.Now(); clock.Now().Sub(start) < 10*time.Second; {
+		for _, w := range writers {
+			if _, err := w.Write(buf); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Allow for a quantum's worth of units on
+	// either side of the boundary between quanta.
+	if peak, max := total.PeakRate(time.Second), float64(parentBPS+2*parentBPS/10); peak > max {
+		t.Errorf("children together peaked at %.0f units/s; want at most %.0f", peak, max)
+	}
+	if r := total.Rate(); r < parentBPS*0.95 || r > parentBPS*1.05 {
+		t.Errorf("children together averaged %.0f units/s; want %d", r, parentBPS)
+	}
+	for i, r := range recs {
+		if peak, max := r.PeakRate(time.Second), float64(childBPS+2*childBPS/10); peak > max {
+			t.Errorf("child %d peaked at %.0f units/s; want at most %.0f", i, peak, max)
+		}
+	}
+}
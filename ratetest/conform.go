@@ -0,0 +1,229 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ratetest
+
+import (
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joshlf/rate"
+)
+
+// A Clock is a simulated rate.Clock. Sleeping advances
+// the time immediately, so limiters using a Clock run
+// as fast as possible, and deterministically. It is
+// intended to be used from a single goroutine.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a new Clock whose time is t.
+func NewClock(t time.Time) *Clock {
+	return &Clock{now: t}
+}
+
+// Now returns the simulated time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Sleep advances the simulated time by d.
+func (c *Clock) Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// A Step is a single step of a Workload: after idling
+// for Idle, the workload writes N bytes in a single call.
+type Step struct {
+	Idle time.Duration
+	N    int
+}
+
+// A Workload is a scripted pattern of writes used to
+// exercise a limiter.
+type Workload struct {
+	Name string
+	// Steps returns the steps of the workload for a
+	// limiter configured with the given rate.
+	Steps func(bps uint64) []Step
+	// Saturating is true if the workload always has
+	// more data to write than the limiter allows, so
+	// that its average rate should match the limit.
+	Saturating bool
+}
+
+// Workloads is the set of workloads run by Conform.
+var Workloads = []Workload{
+	{
+		Name: "steady",
+		Steps: func(bps uint64) []Step {
+			return repeat(100, Step{N: size(bps / 10)})
+		},
+		Saturating: true,
+	},
+	{
+		Name: "large",
+		Steps: func(bps uint64) []Step {
+			return repeat(4, Step{N: size(bps * 5 / 2)})
+		},
+		Saturating: true,
+	},
+	{
+		Name: "small",
+		Steps: func(bps uint64) []Step {
+			n := size(bps / 500)
+			return repeat(int(2*bps)/n, Step{N: n})
+		},
+		Saturating: true,
+	},
+	{
+		Name: "stalls",
+		Steps: func(bps uint64) []Step {
+			var steps []Step
+			for i := 0; i < 5; i++ {
+				steps = append(steps, Step{Idle: 3 * time.Second, N: size(bps / 10)})
+				steps = append(steps, repeat(19, Step{N: size(bps / 10)})...)
+			}
+			return steps
+		},
+	},
+	{
+		Name: "bursty",
+		Steps: func(bps uint64) []Step {
+			rnd := rand.New(rand.NewSource(1))
+			steps := make([]Step, 200)
+			for i := range steps {
+				steps[i].N = 1 + rnd.Intn(size(bps/4))
+				if rnd.Intn(4) == 0 {
+					steps[i].Idle = time.Duration(rnd.Int63n(int64(time.Second)))
+				}
+			}
+			return steps
+		},
+	},
+}
+
+func size(n uint64) int {
+	if n == 0 {
+		return 1
+	}
+	return int(n)
+}
+
+func repeat(n int, s Step) []Step {
+	steps := make([]Step, n)
+	for i := range steps {
+		steps[i] = s
+	}
+	return steps
+}
+
+// A Factory creates the limiter under test, which must write
+// to w, and must use c for all timekeeping (for example, by
+// passing rate.WithClock(c) to its constructor).
+type Factory func(w io.Writer, c rate.Clock) io.Writer
+
+// Conform runs each of the Workloads against limiters created
+// by newLimiter in simulated time, and verifies that:
+//
+//   - the limiter never exceeds its burst; that is, over any
+//     interval of length d, at most burst + bps*d bytes are
+//     written. If burst is negative, this is not checked.
+//     Note that limiters which allot a fixed budget to each
+//     quantum may burst up to two quanta's worth of bytes
+//     around the boundary between quanta.
+//   - over the course of any workload, the average rate, less
+//     the burst, does not exceed bps by more than a fraction
+//     epsilon, and, for saturating workloads, the average rate
+//     is at least bps less a fraction epsilon.
+//   - every byte written by the workload reaches the underlying
+//     writer.
+//
+// Each workload is run as a subtest.
+func Conform(t *testing.T, newLimiter Factory, bps uint64, burst int64, epsilon float64) {
+	for _, wl := range Workloads {
+		wl := wl
+		t.Run(wl.Name, func(t *testing.T) {
+			c := NewClock(time.Unix(0, 0))
+			rec := NewRecorderClock(c)
+			w := newLimiter(rec, c)
+
+			var want int64
+			for _, s := range wl.Steps(bps) {
+				c.Sleep(s.Idle)
+				if _, err := w.Write(make([]byte, s.N)); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				want += int64(s.N)
+			}
+			if got := rec.Total(); got != want {
+				t.Errorf("wrote %d bytes, want %d", got, want)
+			}
+
+			// The workload is over once the limiter would
+			// allow it to write again; otherwise, the budget
+			// for the last quantum (or its equivalent) would
+			// not be accounted for.
+			if _, err := w.Write([]byte{0}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			d := c.Now().Sub(rec.start).Seconds()
+
+			writes := rec.Writes()
+			if burst >= 0 {
+				// Allow for rounding error.
+				if excess := maxExcess(writes, bps); excess > float64(burst)+0.5 {
+					t.Errorf("burst of %.0f bytes exceeds allowed burst of %d", excess, burst)
+				}
+			}
+
+			// A limiter may write its burst at once, on top
+			// of its rate, so only the bytes beyond the burst
+			// count against the rate.
+			sustained := want
+			if burst > 0 {
+				sustained -= burst
+			}
+			if avg := float64(sustained) / d; avg > float64(bps)*(1+epsilon) {
+				t.Errorf("average rate %.1f, less the burst, exceeds %d by more than %.0f%%", avg, bps, epsilon*100)
+			}
+			if avg := float64(want) / d; wl.Saturating && avg < float64(bps)*(1-epsilon) {
+				t.Errorf("average rate %.1f falls short of %d by more than %.0f%%", avg, bps, epsilon*100)
+			}
+		})
+	}
+}
+
+// maxExcess returns the largest number of bytes by which
+// the writes exceed the envelope bps*d over any interval.
+func maxExcess(writes []Write, bps uint64) float64 {
+	// For writes i <= j, the excess over the interval from
+	// i to j is cum[j] - cum[i-1] - bps*(t[j]-t[i]). For
+	// each j, maximize it by tracking the minimum over i
+	// of cum[i-1] - bps*t[i].
+	var excess, cum, min float64
+	for j, w := range writes {
+		t := float64(w.Time.UnixNano()) / 1e9
+		if v := cum - float64(bps)*t; j == 0 || v < min {
+			min = v
+		}
+		cum += float64(w.N)
+		if e := cum - float64(bps)*t - min; e > excess {
+			excess = e
+		}
+	}
+	return excess
+}
@@ -0,0 +1,358 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ratetest
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/joshlf/rate"
+)
+
+// ErrInjected is the error returned by reads and writes
+// which a simulated network fails deliberately.
+var ErrInjected = errors.New("ratetest: injected network failure")
+
+// A Profile describes the conditions of a simulated network.
+type Profile struct {
+	Name string
+	// Down and Up are the rates, in bytes per second, at
+	// which data is received and sent. If either is 0,
+	// data is not limited in that direction.
+	Down, Up uint64
+	// Latency is added to the time at which each byte
+	// received becomes available to be read. Jitter is
+	// the maximum amount by which Latency is randomly
+	// increased or decreased; data is never reordered.
+	Latency, Jitter time.Duration
+	// ShortReads is the probability that a read returns
+	// fewer bytes than were requested and available.
+	ShortReads float64
+	// ErrorRate is the probability that a read or write
+	// fails with ErrInjected. Each read or write fails
+	// independently, so that a stream may be read or
+	// written again after a failure.
+	ErrorRate float64
+	// Seed seeds the random choices, so that a test can
+	// be made repeatable. If it is 0, a random seed is
+	// used.
+	Seed int64
+}
+
+// Profiles of some common kinds of network. They do not
+// fail reads or writes; set ErrorRate to simulate failures.
+var (
+	DSL = Profile{
+		Name:       "DSL",
+		Down:       1 << 20,
+		Up:         128 << 10,
+		Latency:    25 * time.Millisecond,
+		Jitter:     5 * time.Millisecond,
+		ShortReads: 0.05,
+	}
+	Mobile3G = Profile{
+		Name:       "3G",
+		Down:       96 << 10,
+		Up:         32 << 10,
+		Latency:    100 * time.Millisecond,
+		Jitter:     40 * time.Millisecond,
+		ShortReads: 0.2,
+	}
+	Satellite = Profile{
+		Name:       "satellite",
+		Down:       2 << 20,
+		Up:         384 << 10,
+		Latency:    600 * time.Millisecond,
+		Jitter:     50 * time.Millisecond,
+		ShortReads: 0.05,
+	}
+)
+
+// sim holds the random state of a simulated stream.
+type sim struct {
+	p   Profile
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func newSim(p Profile) *sim {
+	seed := p.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &sim{p: p, rnd: rand.New(rand.NewSource(seed))}
+}
+
+func (s *sim) float() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rnd.Float64()
+}
+
+func (s *sim) fail() bool { return s.p.ErrorRate > 0 && s.float() < s.p.ErrorRate }
+
+// latency returns the latency of a chunk of data.
+func (s *sim) latency() time.Duration {
+	if s.p.Jitter <= 0 {
+		return s.p.Latency
+	}
+	return s.p.Latency + time.Duration((2*s.float()-1)*float64(s.p.Jitter))
+}
+
+// shorten returns the number of bytes, at most n,
+// which a read should return.
+func (s *sim) shorten(n int) int {
+	if n > 1 && s.p.ShortReads > 0 && s.float() < s.p.ShortReads {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return 1 + s.rnd.Intn(n-1)
+	}
+	return n
+}
+
+func limitOpts(bps uint64) []rate.Option {
+	if bps == 0 {
+		return nil
+	}
+	return []rate.Option{rate.WithLimit(bps)}
+}
+
+type chunk struct {
+	b   []byte
+	at  time.Time // when b becomes available
+	err error
+}
+
+// A simReader delays the data read from r, reading
+// ahead in a separate goroutine.
+type simReader struct {
+	s        *sim
+	ch       chan chunk
+	cur      chunk
+	deadline deadline
+	done     chan struct{} // closed by close
+	stop     sync.Once
+}
+
+// NewReader returns an io.Reader which reads from r as
+// though over a network with the conditions described by
+// p, in the direction in which data is received (limited
+// to p.Down bytes per second). It reads ahead from r in a
+// separate goroutine, which exits once a read from r fails.
+func NewReader(r io.Reader, p Profile) io.Reader {
+	return newSimReader(r, newSim(p))
+}
+
+func newSimReader(r io.Reader, s *sim) *simReader {
+	sr := &simReader{s: s, ch: make(chan chunk, 64), done: make(chan struct{})}
+	r = rate.NewReader(r, limitOpts(s.p.Down)...)
+	go func() {
+		var last time.Time
+		for {
+			b := make([]byte, 32<<10)
+			n, err := r.Read(b)
+			at := time.Now().Add(s.latency())
+			if at.Before(last) {
+				at = last
+			}
+			last = at
+			select {
+			case sr.ch <- chunk{b: b[:n], at: at, err: err}:
+			case <-sr.done:
+				return
+			}
+			if err != nil {
+				close(sr.ch)
+				return
+			}
+		}
+	}()
+	return sr
+}
+
+func (sr *simReader) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	expired := sr.deadline.wait()
+	for len(sr.cur.b) == 0 && sr.cur.err == nil {
+		select {
+		case c, ok := <-sr.ch:
+			if !ok {
+				return 0, io.EOF
+			}
+			sr.cur = c
+		case <-expired:
+			return 0, os.ErrDeadlineExceeded
+		case <-sr.done:
+			return 0, net.ErrClosed
+		}
+	}
+	if d := time.Until(sr.cur.at); d > 0 {
+		// The data is kept for the next Read
+		// if this one times out.
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-expired:
+			t.Stop()
+			return 0, os.ErrDeadlineExceeded
+		case <-sr.done:
+			t.Stop()
+			return 0, net.ErrClosed
+		}
+	}
+	if sr.s.fail() {
+		return 0, ErrInjected
+	}
+	if len(sr.cur.b) == 0 {
+		return 0, sr.cur.err
+	}
+	n = copy(p[:sr.s.shorten(len(p))], sr.cur.b)
+	sr.cur.b = sr.cur.b[n:]
+	return n, nil
+}
+
+// close makes the read-ahead goroutine exit once its
+// read in progress returns, and fails blocked Reads.
+func (sr *simReader) close() {
+	sr.stop.Do(func() { close(sr.done) })
+}
+
+// A deadline closes a channel when it passes, so that
+// waits can select on it, as with the deadlines of the
+// ends of a net.Pipe.
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{} // closed once the deadline passes
+}
+
+// set sets the deadline to t; the zero value
+// of time.Time means that there is no deadline.
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cancel == nil {
+		d.cancel = make(chan struct{})
+	}
+	if d.timer != nil && !d.timer.Stop() {
+		// The timer has fired; wait for
+		// it to close the channel.
+		<-d.cancel
+	}
+	d.timer = nil
+	closed := isClosed(d.cancel)
+	if dur := time.Until(t); t.IsZero() || dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		if !t.IsZero() {
+			cancel := d.cancel
+			d.timer = time.AfterFunc(dur, func() { close(cancel) })
+		}
+		return
+	}
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+// wait returns a channel which is closed
+// once the deadline passes.
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cancel == nil {
+		d.cancel = make(chan struct{})
+	}
+	return d.cancel
+}
+
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// A simWriter writes to w as though over a simulated network.
+type simWriter struct {
+	s *sim
+	w io.Writer
+}
+
+// NewWriter returns an io.Writer which writes to w as
+// though over a network with the conditions described
+// by p, in the direction in which data is sent (limited
+// to p.Up bytes per second). Latency applies only to
+// data received, so it does not delay writes.
+func NewWriter(w io.Writer, p Profile) io.Writer {
+	s := newSim(p)
+	return &simWriter{s: s, w: rate.NewWriter(w, limitOpts(p.Up)...)}
+}
+
+func (sw *simWriter) Write(p []byte) (n int, err error) {
+	if sw.s.fail() {
+		return 0, ErrInjected
+	}
+	return sw.w.Write(p)
+}
+
+// A Conn is a net.Conn which simulates the
+// conditions of a network (see NewConn).
+type Conn struct {
+	net.Conn
+	r *simReader
+	w *simWriter
+}
+
+// NewConn returns a Conn which reads from and writes to c
+// as though over a network with the conditions described
+// by p. Since Latency applies only to data received, it
+// adds Latency to the round trip time of c; to simulate
+// both directions, wrap the connections at both ends. A
+// goroutine reads ahead from c until a read from it fails
+// or the Conn is closed. Read deadlines set on the Conn
+// apply to Read, which waits for the data read ahead, and
+// not to the reads from c.
+func NewConn(c net.Conn, p Profile) *Conn {
+	s := newSim(p)
+	return &Conn{
+		Conn: c,
+		r:    newSimReader(c, s),
+		w:    &simWriter{s: s, w: rate.NewWriter(c, limitOpts(p.Up)...)},
+	}
+}
+
+func (c *Conn) Read(p []byte) (n int, err error)  { return c.r.Read(p) }
+func (c *Conn) Write(p []byte) (n int, err error) { return c.w.Write(p) }
+
+// Close closes c's underlying connection, and stops
+// reading ahead from it.
+func (c *Conn) Close() error {
+	c.r.close()
+	return c.Conn.Close()
+}
+
+// SetDeadline sets the read deadline of c, and the
+// write deadline of its underlying connection.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.r.deadline.set(t)
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline of c's Reads.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.r.deadline.set(t)
+	return nil
+}
@@ -0,0 +1,53 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ratetest
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestConnReadDeadline tests that a read deadline which
+// passes while a Conn waits for data fails only that
+// Read, and not later ones, and that Close fails Reads.
+func TestConnReadDeadline(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := NewConn(a, Profile{Latency: 10 * time.Millisecond, Seed: 1})
+
+	buf := make([]byte, 16)
+	c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := c.Read(buf); err == nil {
+		t.Fatal("Read succeeded with no data")
+	} else if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("got error %v; want a timeout", err)
+	}
+
+	c.SetReadDeadline(time.Time{})
+	go b.Write([]byte("hello"))
+	n, err := c.Read(buf)
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("got %q, %v after the deadline passed; want %q, nil", buf[:n], err, "hello")
+	}
+
+	// A deadline set during a Read applies to it.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		c.SetDeadline(time.Now())
+	}()
+	if _, err := c.Read(buf); err == nil {
+		t.Fatal("Read succeeded with no data")
+	}
+
+	c.SetDeadline(time.Time{})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		c.Close()
+	}()
+	if _, err := c.Read(buf); err == nil {
+		t.Fatal("Read succeeded after Close")
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ratetest provides utilities for testing that
+// code which uses package rate honors its configured
+// limits.
+package ratetest
+
+import (
+	"io"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joshlf/rate"
+)
+
+// A Write records a single call to a Recorder's Write method.
+type Write struct {
+	Time time.Time
+	N    int
+}
+
+// A Recorder is an io.Writer which discards the data written
+// to it, but records how much was written and when. It is
+// safe for concurrent use.
+type Recorder struct {
+	clock  rate.Clock
+	mu     sync.Mutex
+	start  time.Time
+	writes []Write
+	total  int64
+}
+
+// NewRecorder returns a new Recorder. Rates are measured
+// from the time at which it is created.
+func NewRecorder() *Recorder {
+	return &Recorder{start: time.Now()}
+}
+
+// NewRecorderClock is like NewRecorder, but the returned
+// Recorder uses c to tell the time.
+func NewRecorderClock(c rate.Clock) *Recorder {
+	return &Recorder{clock: c, start: c.Now()}
+}
+
+func (r *Recorder) now() time.Time {
+	if r.clock == nil {
+		return time.Now()
+	}
+	return r.clock.Now()
+}
+
+func (r *Recorder) Write(p []byte) (n int, err error) {
+	r.mu.Lock()
+	r.writes = append(r.writes, Write{r.now(), len(p)})
+	r.total += int64(len(p))
+	r.mu.Unlock()
+	return len(p), nil
+}
+
+// Writes returns the writes recorded so far.
+func (r *Recorder) Writes() []Write {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Write(nil), r.writes...)
+}
+
+// Total returns the total number of bytes written.
+func (r *Recorder) Total() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.total
+}
+
+// Rate returns the average rate, in bytes per second, at
+// which bytes were written between the creation of r and
+// the last write.
+func (r *Recorder) Rate() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.writes) == 0 {
+		return 0
+	}
+	d := r.writes[len(r.writes)-1].Time.Sub(r.start)
+	if d <= 0 {
+		return math.Inf(1)
+	}
+	return float64(r.total) / d.Seconds()
+}
+
+// PeakRate returns the highest average rate, in bytes per
+// second, over any interval of length window starting at
+// one of the recorded writes. It is useful for verifying
+// that bursts stay within bounds.
+func (r *Recorder) PeakRate(window time.Duration) float64 {
+	writes := r.Writes()
+	var peak float64
+	var sum int64
+	j := 0
+	for i := range writes {
+		for ; j < len(writes) && writes[j].Time.Sub(writes[i].Time) < window; j++ {
+			sum += int64(writes[j].N)
+		}
+		if rate := float64(sum) / window.Seconds(); rate > peak {
+			peak = rate
+		}
+		sum -= int64(writes[i].N)
+	}
+	return peak
+}
+
+// MeasureRate calls f with a Recorder, and returns the average
+// rate, in bytes per second, at which f wrote to it, measured
+// from the time f was called until it returned.
+func MeasureRate(f func(w io.Writer)) float64 {
+	r := NewRecorder()
+	f(r)
+	d := r.now().Sub(r.start)
+	return float64(r.Total()) / d.Seconds()
+}
+
+// AssertRate calls f with a Recorder, and fails t unless the
+// average rate at which f wrote to it (as measured by
+// MeasureRate) is within tolerance of want. The tolerance is
+// a fraction of want; for example, 0.1 allows an error of 10%.
+func AssertRate(t testing.TB, f func(w io.Writer), want, tolerance float64) {
+	t.Helper()
+	got := MeasureRate(f)
+	if math.Abs(got-want) > want*tolerance {
+		t.Errorf("rate = %.1f/s, want %.1f/s ± %.0f%%", got, want, tolerance*100)
+	}
+}
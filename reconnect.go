@@ -0,0 +1,211 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// A ReconnectConn is a connection which transparently
+// reconnects when reading from or writing to it fails. Its
+// rates are limited and monitored as for a Conn, but the
+// state of its limiters and monitors carries over across
+// reconnects, so that the connection is limited and
+// reported as a single stream.
+//
+// Bytes which were in flight when the connection failed
+// may be lost, so a ReconnectConn is only suitable for
+// protocols which tolerate this, such as those which
+// resynchronize at message boundaries.
+type ReconnectConn struct {
+	dial func() (net.Conn, error)
+	m    *Monitor
+	r    *Reader
+	w    *Writer
+
+	mu     sync.Mutex
+	c      net.Conn
+	gen    uint64 // incremented on every reconnect
+	closed bool
+	rd, wd time.Time // deadlines, reapplied on reconnect
+}
+
+// NewReconnectConn calls dial to connect, and returns a new
+// ReconnectConn which calls dial again whenever reading or
+// writing fails. The rates at which bytes are read and
+// written are limited and monitored according to readOpts
+// and writeOpts respectively, as for NewReader and
+// NewWriter. Every reconnect is counted by reconnects,
+// so that reconnects appear in its stream of rates; it
+// may be nil.
+//
+// Reaching the end of the stream (io.EOF) and timeouts do
+// not cause a reconnect. If dial fails, the error is returned
+// from the Read or Write which attempted to reconnect.
+func NewReconnectConn(dial func() (net.Conn, error), reconnects *Monitor, readOpts, writeOpts []Option) (*ReconnectConn, error) {
+	c, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	rc := &ReconnectConn{dial: dial, m: reconnects, c: c}
+	rc.r = NewReader(reconnectReader{rc}, readOpts...)
+	rc.w = NewWriter(reconnectWriter{rc}, writeOpts...)
+	return rc, nil
+}
+
+func (c *ReconnectConn) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	return
+}
+
+func (c *ReconnectConn) Write(p []byte) (n int, err error) {
+	n, err = c.w.Write(p)
+	return
+}
+
+// conn returns the current connection and its generation.
+func (c *ReconnectConn) conn() (net.Conn, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.c, c.gen
+}
+
+// redial replaces the connection of generation gen, which
+// failed with err. If it has already been replaced, redial
+// does nothing. If c has been closed, redial returns err.
+func (c *ReconnectConn) redial(gen uint64, err error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return err
+	}
+	if gen != c.gen {
+		return nil
+	}
+	nc, err := c.dial()
+	if err != nil {
+		return err
+	}
+	c.c.Close()
+	c.c = nc
+	c.gen++
+	if !c.rd.IsZero() {
+		nc.SetReadDeadline(c.rd)
+	}
+	if !c.wd.IsZero() {
+		nc.SetWriteDeadline(c.wd)
+	}
+	c.m.Add(1)
+	return nil
+}
+
+// Reconnects returns the number of times
+// c has reconnected.
+func (c *ReconnectConn) Reconnects() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// reconnectable returns whether err warrants a reconnect.
+func reconnectable(err error) bool {
+	if err == nil || err == io.EOF {
+		return false
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return false
+	}
+	return true
+}
+
+type reconnectReader struct{ c *ReconnectConn }
+
+func (r reconnectReader) Read(p []byte) (n int, err error) {
+	for {
+		c, gen := r.c.conn()
+		n, err = c.Read(p)
+		if n > 0 || !reconnectable(err) {
+			return
+		}
+		if err = r.c.redial(gen, err); err != nil {
+			return
+		}
+	}
+}
+
+type reconnectWriter struct{ c *ReconnectConn }
+
+func (w reconnectWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		c, gen := w.c.conn()
+		var nn int
+		nn, err = c.Write(p)
+		n += nn
+		p = p[nn:]
+		if !reconnectable(err) {
+			if err != nil {
+				return
+			}
+			continue
+		}
+		if err = w.c.redial(gen, err); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Close closes the current connection, and
+// prevents c from reconnecting.
+func (c *ReconnectConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return c.c.Close()
+}
+
+// LocalAddr returns the local address of
+// the current connection.
+func (c *ReconnectConn) LocalAddr() net.Addr {
+	nc, _ := c.conn()
+	return nc.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of
+// the current connection.
+func (c *ReconnectConn) RemoteAddr() net.Addr {
+	nc, _ := c.conn()
+	return nc.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines of the
+// current connection, and of any future connections.
+func (c *ReconnectConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rd, c.wd = t, t
+	return c.c.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the current
+// connection, and of any future connections.
+func (c *ReconnectConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rd = t
+	return c.c.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the current
+// connection, and of any future connections.
+func (c *ReconnectConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wd = t
+	return c.c.SetWriteDeadline(t)
+}
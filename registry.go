@@ -0,0 +1,72 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// The registry holds the Monitors which have been given
+// names with Register, so that tools (such as cmd/ratetop)
+// can list all of the streams in a process.
+var registry struct {
+	sync.Mutex
+	monitors map[string]*Monitor
+}
+
+// Register makes m visible under name to Registered and
+// to the handler returned by RegistryHandler. If another
+// Monitor was registered under name, it is replaced.
+func Register(name string, m *Monitor) {
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.monitors == nil {
+		registry.monitors = make(map[string]*Monitor)
+	}
+	registry.monitors[name] = m
+}
+
+// Unregister removes the Monitor registered under name.
+// It should be called when the Monitor is closed.
+func Unregister(name string) {
+	registry.Lock()
+	defer registry.Unlock()
+	delete(registry.monitors, name)
+}
+
+// An Entry describes a registered Monitor.
+type Entry struct {
+	Name string
+	// Rate is the Monitor's Snapshot.
+	Rate Rate
+}
+
+// Registered returns an Entry for each registered
+// Monitor, sorted by name.
+func Registered() []Entry {
+	registry.Lock()
+	entries := make([]Entry, 0, len(registry.monitors))
+	for name, m := range registry.monitors {
+		entries = append(entries, Entry{Name: name, Rate: m.Snapshot()})
+	}
+	registry.Unlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries
+}
+
+// RegistryHandler returns an http.Handler which serves
+// the result of Registered as JSON. It is intended to be
+// mounted on an administrative endpoint:
+//
+//	http.Handle("/debug/rate", rate.RegistryHandler())
+func RegistryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Registered())
+	})
+}
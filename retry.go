@@ -0,0 +1,109 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 10 * time.Second
+)
+
+// A Retrier retries failed operations with exponential
+// backoff, while limiting the rate of retries with a
+// Limiter. When the Limiter is shared by every Retrier in a
+// process (or a single Retrier is used by every goroutine),
+// the retries of all operations combined never exceed its
+// rate, so that a failure of a shared dependency does not
+// turn into a storm of retries against it:
+//
+//	r := &rate.Retrier{Limiter: rate.NewPacer(10)} // 10 retries/s
+//	err := r.Do(ctx, func(ctx context.Context) error {
+//		return call(ctx)
+//	})
+//
+// A Retrier is safe for concurrent use.
+type Retrier struct {
+	// Limiter limits the rate of retries; the first
+	// attempt at each operation is not limited. If it
+	// is nil, retries are only limited by backoff.
+	Limiter Limiter
+	// MinBackoff and MaxBackoff bound the backoff before
+	// each retry, which doubles with every attempt. The
+	// defaults are 100ms and 10s. Backoffs are jittered.
+	MinBackoff, MaxBackoff time.Duration
+	// MaxAttempts is the maximum number of attempts,
+	// including the first. If it is 0, attempts are
+	// made until ctx is done.
+	MaxAttempts int
+}
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+
+// Permanent wraps err to signal to Retrier.Do that
+// the operation which returned it should not be
+// retried. Do returns err unwrapped.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// Do calls f until it succeeds, returns an error wrapped
+// by Permanent, or r.MaxAttempts attempts have been made.
+// Before each retry, it backs off, and then waits for the
+// Limiter. If ctx is done while Do is waiting, or all of
+// the attempts fail, Do returns the error returned by the
+// last attempt.
+func (r *Retrier) Do(ctx context.Context, f func(ctx context.Context) error) error {
+	min, max := r.MinBackoff, r.MaxBackoff
+	if min <= 0 {
+		min = defaultMinBackoff
+	}
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
+	backoff := min
+	for attempt := 1; ; attempt++ {
+		err := f(ctx)
+		if err == nil {
+			return nil
+		}
+		if p, ok := err.(permanentError); ok {
+			return p.err
+		}
+		if r.MaxAttempts > 0 && attempt >= r.MaxAttempts {
+			return err
+		}
+
+		// Full jitter: sleep for a random duration of
+		// up to the backoff, so that operations which
+		// failed together do not retry together.
+		t := time.NewTimer(time.Duration(rand.Int63n(int64(backoff)) + 1))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+		if r.Limiter != nil {
+			if r.Limiter.WaitN(ctx, 1) != nil {
+				return err
+			}
+		}
+		if backoff < max/2 {
+			backoff *= 2
+		} else {
+			backoff = max
+		}
+	}
+}
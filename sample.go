@@ -0,0 +1,73 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// A SampledCounter counts events for a Monitor by sampling,
+// for code paths so hot that even counting with a
+// LocalCounter is too expensive. Each call to Add is sampled
+// with probability 1/n, and a sampled call adds n times as
+// many events to the Monitor's total, so that the total and
+// rates reported by the Monitor are unbiased estimates. The
+// standard error of each rate is reported in the RateError
+// field of the Rate.
+//
+// A SampledCounter's Add method should be called by only one
+// goroutine at a time; each goroutine should use its own.
+type SampledCounter struct {
+	m    *Monitor
+	n    uint64
+	rand uint64 // xorshift state
+}
+
+var _ Counter = (*SampledCounter)(nil)
+
+// Sampled returns a new SampledCounter for m which samples
+// one in n calls to Add. If n <= 1, every call is counted.
+func (m *Monitor) Sampled(n int) *SampledCounter {
+	if n < 1 {
+		n = 1
+	}
+	seed := uint64(time.Now().UnixNano()) | 1
+	return &SampledCounter{m: m, n: uint64(n), rand: seed}
+}
+
+// Add counts n events, if the call is sampled.
+func (s *SampledCounter) Add(n uint64) {
+	if s.n > 1 {
+		// xorshift64
+		s.rand ^= s.rand << 13
+		s.rand ^= s.rand >> 7
+		s.rand ^= s.rand << 17
+		if s.rand%s.n != 0 {
+			return
+		}
+	}
+	s.m.Add(n * s.n)
+	if s.n > 1 {
+		// Each sampled call contributes n*(n-1)*x^2
+		// to an unbiased estimate of the variance
+		// of the sampled total.
+		x := float64(n)
+		s.m.addVariance(float64(s.n) * float64(s.n-1) * x * x)
+	}
+}
+
+// addVariance adds v to the variance of the
+// current period's estimated total.
+func (m *Monitor) addVariance(v float64) {
+	for {
+		old := atomic.LoadUint64(&m.variance)
+		nv := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&m.variance, old, nv) {
+			return
+		}
+	}
+}
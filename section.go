@@ -0,0 +1,87 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import "io"
+
+// A SectionReader is like an io.SectionReader, except that
+// it may limit and monitor the rate at which bytes are read
+// from its section, whether by Read or by ReadAt. It allows
+// consumers which read ranges of a file, such as archive
+// readers and resumable downloads, to be throttled.
+type SectionReader struct {
+	sr *io.SectionReader
+	stream
+}
+
+// NewSectionReader returns a new SectionReader which reads
+// from r starting at offset off and stops with io.EOF after
+// n bytes, as io.NewSectionReader does. Unless it is given
+// WithLimiter, WithLimit, or WithMonitor, it neither limits
+// nor monitors its rate.
+func NewSectionReader(r io.ReaderAt, off, n int64, opts ...Option) *SectionReader {
+	return &SectionReader{sr: io.NewSectionReader(r, off, n), stream: newStream(opts)}
+}
+
+// LimitSectionReader returns a new SectionReader which reads
+// from the section of r at a maximum rate of bps bytes per
+// second. To make sections share a budget as well, give each
+// WithParent, with the same parent.
+func LimitSectionReader(r io.ReaderAt, off, n int64, bps uint64, opts ...LimitOption) *SectionReader {
+	return NewSectionReader(r, off, n, WithLimit(bps, opts...))
+}
+
+// MonitorSectionReader returns a new SectionReader which
+// counts the bytes read from the section of r using m.
+func MonitorSectionReader(r io.ReaderAt, off, n int64, m *Monitor) *SectionReader {
+	return NewSectionReader(r, off, n, WithMonitor(m))
+}
+
+func (s *SectionReader) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return
+	}
+	k, err := s.take(len(p), true)
+	if err != nil {
+		return
+	}
+	n, err = s.sr.Read(p[:k])
+	if werr := s.done(k, n, true); err == nil {
+		err = werr
+	}
+	return
+}
+
+// ReadAt reads len(p) bytes from the section starting at
+// offset off, waiting for the limiter as many times as
+// necessary. It may be called concurrently.
+func (s *SectionReader) ReadAt(p []byte, off int64) (n int, err error) {
+	for len(p) > 0 {
+		var k, nn int
+		k, err = s.take(len(p), false)
+		if err != nil {
+			return
+		}
+		nn, err = s.sr.ReadAt(p[:k], off)
+		s.settle(k, nn, false)
+		n += nn
+		off += int64(nn)
+		p = p[nn:]
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Seek implements io.Seeker.
+func (s *SectionReader) Seek(offset int64, whence int) (int64, error) {
+	return s.sr.Seek(offset, whence)
+}
+
+// Size returns the size of the section in bytes.
+func (s *SectionReader) Size() int64 {
+	return s.sr.Size()
+}
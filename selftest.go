@@ -0,0 +1,72 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"io/ioutil"
+	"time"
+)
+
+// A SelfTestResult describes how closely a Pacer held to its
+// configured rate in a run of SelfTest.
+type SelfTestResult struct {
+	// Configured is the rate with which the Pacer was
+	// configured, and Achieved is the average rate
+	// actually achieved over the whole run, in bytes
+	// per second.
+	Configured uint64
+	Achieved   float64
+	// Jitter is the standard deviation of the rates
+	// achieved over each of the Periods periods of the
+	// run. A high Jitter means the rate is bursty at the
+	// time scale of the period, which is a twentieth of
+	// the run (but at least 10ms).
+	Jitter  float64
+	Periods uint64
+	Elapsed time.Duration
+}
+
+// SelfTest runs a synthetic stream of bytes into a sink which
+// discards them for d, through a Pacer created by NewPacer(bps,
+// opts...), and reports how closely the Pacer held to bps. It
+// allows applications to validate settings such as the quantum
+// programmatically, on the machine on which they will be used.
+// If bps == 0, SelfTest returns immediately.
+func SelfTest(bps uint64, d time.Duration, opts ...LimitOption) SelfTestResult {
+	res := SelfTestResult{Configured: bps}
+	if bps == 0 {
+		return res
+	}
+	period := d / 20
+	if period < 10*time.Millisecond {
+		period = 10 * time.Millisecond
+	}
+
+	// Write small enough chunks that the run
+	// does not overrun d by much.
+	size := bps / 100
+	switch {
+	case size == 0:
+		size = 1
+	case size > limitChunk:
+		size = limitChunk
+	}
+	buf := make([]byte, size)
+
+	m := NewMonitor(WithPeriod(period))
+	w := NewWriter(ioutil.Discard, WithLimiter(NewPacer(bps, opts...)), WithMonitor(m))
+	start := time.Now()
+	for time.Since(start) < d {
+		w.Write(buf)
+	}
+	res.Elapsed = time.Since(start)
+	m.Close()
+
+	s := m.Stats()
+	res.Achieved = float64(m.Total()) / res.Elapsed.Seconds()
+	res.Jitter = s.StdDev
+	res.Periods = s.Periods
+	return res
+}
@@ -0,0 +1,72 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"io"
+	"sync"
+)
+
+// A Session monitors a logical transfer which is carried out
+// by several sequential attempts, such as a download which is
+// retried after a failure, so that the progress reported
+// reflects the transfer as a whole rather than the current
+// attempt. Each attempt reads or writes through a Reader or
+// Writer obtained from the Session, which counts its bytes
+// using the Session's Monitor.
+//
+// When an attempt starts, it gives the offset from which it
+// resumes. Bytes which earlier attempts transferred past that
+// offset must be transferred again, so they are reclassified
+// as wasted (see Monitor.Waste), and the Monitor's Total is
+// always the number of bytes of the transfer which are done.
+type Session struct {
+	*Monitor
+
+	mu       sync.Mutex
+	attempts int
+}
+
+// NewSession creates a new Session, whose Monitor is
+// configured by opts as for NewMonitor.
+func NewSession(opts ...MonitorOption) *Session {
+	return &Session{Monitor: NewMonitor(opts...)}
+}
+
+// start starts a new attempt which resumes at offset.
+func (s *Session) start(offset uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts++
+	if t := s.Total(); t > offset {
+		s.Waste(t - offset)
+	}
+}
+
+// Reader starts a new attempt, which resumes the transfer at
+// offset by reading from r. opts may limit the attempt's rate,
+// but should not give it a Monitor or Counter. Readers from
+// earlier attempts should no longer be used.
+func (s *Session) Reader(r io.Reader, offset uint64, opts ...Option) *Reader {
+	s.start(offset)
+	return NewReader(r, append(opts[:len(opts):len(opts)], WithMonitor(s.Monitor))...)
+}
+
+// Writer starts a new attempt, which resumes the transfer at
+// offset by writing to w. opts may limit the attempt's rate,
+// but should not give it a Monitor or Counter. Writers from
+// earlier attempts should no longer be used.
+func (s *Session) Writer(w io.Writer, offset uint64, opts ...Option) *Writer {
+	s.start(offset)
+	return NewWriter(w, append(opts[:len(opts):len(opts)], WithMonitor(s.Monitor))...)
+}
+
+// Attempts returns the number of attempts
+// started so far.
+func (s *Session) Attempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attempts
+}
@@ -0,0 +1,42 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"io"
+	"net"
+)
+
+// Reader wraps r so that the bytes read from it count
+// against p's limit, along with those of every other
+// stream which p limits. For example, a proxy can cap
+// the combined bandwidth of all of its connections:
+//
+//	lim := rate.NewPacer(10 << 20)
+//	r1 := lim.Reader(a)
+//	w1 := lim.Writer(b)
+//
+// opts configure the Reader as for NewReader; for example,
+// WithMonitor counts its bytes.
+func (p *Pacer) Reader(r io.Reader, opts ...Option) *Reader {
+	return NewReader(r, append([]Option{WithLimiter(p)}, opts...)...)
+}
+
+// Writer wraps w so that the bytes written to it count
+// against p's limit, along with those of every other
+// stream which p limits.
+func (p *Pacer) Writer(w io.Writer, opts ...Option) *Writer {
+	return NewWriter(w, append([]Option{WithLimiter(p)}, opts...)...)
+}
+
+// Conn wraps c so that the bytes read from and written to
+// it count against p's limit, along with those of every
+// other stream which p limits. Reads and writes share
+// the limit; to limit them separately, use NewConn with
+// two Pacers.
+func (p *Pacer) Conn(c net.Conn) *Conn {
+	opts := []Option{WithLimiter(p)}
+	return NewConn(c, opts, opts)
+}
@@ -0,0 +1,59 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+)
+
+// WriteRegistered writes a table describing every
+// registered Monitor (see Register) to w.
+func WriteRegistered(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, e := range Registered() {
+		fmt.Fprintf(tw, "%s\t%s\t%s/s\n", e.Name, FormatBytes(float64(e.Rate.Total)), FormatBytes(e.Rate.Rate))
+	}
+	return tw.Flush()
+}
+
+// DumpOnSignal makes the process write a table describing
+// every registered Monitor to w, as WriteRegistered does,
+// whenever it receives one of sigs, in the manner of dd. If
+// no signals are given, SIGUSR1 is used, except on platforms
+// which have no such signal (such as Windows), where
+// DumpOnSignal does nothing unless signals are given.
+// Calling the returned function stops dumping.
+//
+//	stop := rate.DumpOnSignal(os.Stderr)
+//	defer stop()
+func DumpOnSignal(w io.Writer, sigs ...os.Signal) (stop func()) {
+	if len(sigs) == 0 {
+		sigs = defaultDumpSignals
+	}
+	if len(sigs) == 0 {
+		return func() {}
+	}
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, sigs...)
+	go func() {
+		for {
+			select {
+			case <-ch:
+				WriteRegistered(w)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
@@ -0,0 +1,14 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build windows || plan9 || js || wasip1
+// +build windows plan9 js wasip1
+
+package rate
+
+import "os"
+
+// There is no conventional signal for dumping
+// statistics on these platforms.
+var defaultDumpSignals []os.Signal
@@ -0,0 +1,108 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// defaultETATau is the time constant of the EWMA
+// from which a Monitor's ETA is estimated, if it
+// was not given WithEWMA.
+const defaultETATau = 5 * time.Second
+
+// smoothing holds the state of a Monitor's
+// moving averages. It is only accessed by
+// the Monitor's goroutine.
+type smoothing struct {
+	tau  time.Duration // the time constant of the EWMA, if any
+	ewma float64
+	init bool // whether ewma has been initialized
+
+	// The events (in the units in which rates are
+	// reported) and lengths, in seconds, of the
+	// last periods, for the moving average.
+	events, secs []float64
+	i, n         int
+}
+
+// WithEWMA makes the Monitor report an exponentially
+// weighted moving average of its rate, in the EWMA field of
+// each Rate. The weight of each period decays by a factor
+// of e every tau, so that periods of different lengths (see
+// WithAdaptivePeriod) are weighted consistently; a larger
+// tau gives a smoother, but slower to respond, average.
+func WithEWMA(tau time.Duration) MonitorOption {
+	return func(m *Monitor) {
+		if tau > 0 {
+			m.smoothing.tau = tau
+		}
+	}
+}
+
+// WithMovingAverage makes the Monitor report the average
+// rate over the last n periods, in the MovingAverage field of
+// each Rate. Periods are weighted by their length, so that
+// the average is the number of events in the periods divided
+// by their total length. Until n periods have elapsed, the
+// average is over those which have.
+func WithMovingAverage(n int) MonitorOption {
+	return func(m *Monitor) {
+		if n > 0 {
+			m.smoothing.events = make([]float64, n)
+			m.smoothing.secs = make([]float64, n)
+		}
+	}
+}
+
+// ewma returns the exponentially weighted moving average
+// avg, with time constant tau, updated with a period of
+// length d whose rate was rate.
+func ewma(avg, rate float64, d, tau time.Duration) float64 {
+	alpha := 1 - math.Exp(-d.Seconds()/tau.Seconds())
+	return avg + alpha*(rate-avg)
+}
+
+// smooth updates m's moving averages
+// with r, and sets them in r.
+func (m *Monitor) smooth(r *Rate) {
+	s := &m.smoothing
+	secs := r.Interval.Seconds()
+	expected := atomic.LoadUint64(&m.expected)
+	tau := s.tau
+	if tau == 0 && expected > 0 {
+		tau = defaultETATau
+	}
+	if tau > 0 {
+		if !s.init {
+			s.ewma, s.init = r.Rate, true
+		} else {
+			s.ewma = ewma(s.ewma, r.Rate, r.Interval, tau)
+		}
+		if s.tau > 0 {
+			r.EWMA = s.ewma
+		}
+	}
+	if expected > 0 {
+		m.progress(r, expected, s.ewma)
+	}
+	if len(s.events) > 0 {
+		s.events[s.i], s.secs[s.i] = r.Rate*secs, secs
+		s.i = (s.i + 1) % len(s.events)
+		if s.n < len(s.events) {
+			s.n++
+		}
+		var events, total float64
+		for i := 0; i < s.n; i++ {
+			events += s.events[i]
+			total += s.secs[i]
+		}
+		if total > 0 {
+			r.MovingAverage = events / total
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import "time"
+
+// A Snapshot records a total of events at a point in time.
+// Two Snapshots can be compared with Diff to compute the rate
+// between them, so that callers which only check a counter
+// occasionally (such as a cron job, or a health check) need
+// not run a Monitor's periodic machinery at all:
+//
+//	var m rate.Monitor // a Monitor in pull mode
+//	...
+//	prev := rate.TakeSnapshot(m.Total())
+//	...
+//	r := rate.Diff(prev, rate.TakeSnapshot(m.Total()))
+type Snapshot struct {
+	Time          time.Time
+	Total, Wasted uint64
+}
+
+// TakeSnapshot returns a Snapshot of total at the current
+// time. Wasted may be set separately, if it is counted.
+func TakeSnapshot(total uint64) Snapshot {
+	return Snapshot{Time: time.Now(), Total: total}
+}
+
+// Diff returns the Rate between a and a later Snapshot b: its
+// Total, Wasted, and Time are b's, its Interval is the time
+// between a and b, and its Rate and WasteRate are the rates
+// at which the totals changed over the interval. If b is not
+// later than a, the rates are 0.
+func Diff(a, b Snapshot) Rate {
+	r := Rate{Total: b.Total, Wasted: b.Wasted, Time: b.Time, Interval: b.Time.Sub(a.Time)}
+	if r.Interval > 0 {
+		// The totals may have decreased if
+		// events were reclassified as wasted.
+		r.Rate = float64(int64(b.Total-a.Total)) / r.Interval.Seconds()
+		r.WasteRate = float64(int64(b.Wasted-a.Wasted)) / r.Interval.Seconds()
+	}
+	return r
+}
@@ -0,0 +1,169 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Stats holds statistics about the per-period rates
+// reported by a Monitor since it was created.
+type Stats struct {
+	// Periods is the number of periods for which
+	// a rate has been reported.
+	Periods uint64
+	// Mean is the mean of the per-period rates.
+	Mean float64
+	// Variance and StdDev are the variance and standard
+	// deviation of the per-period rates. They measure how
+	// much the rate jitters from period to period.
+	Variance float64
+	StdDev   float64
+	// Peak is the highest per-period rate, and Min the
+	// lowest which was not 0, or 0 if every period's
+	// rate was.
+	Peak, Min float64
+	// Elapsed is the time since the start of the first
+	// period, and Average is the overall rate over it:
+	// the number of events counted in the periods
+	// divided by Elapsed. Unlike Mean, it weights
+	// periods by their length.
+	Elapsed time.Duration
+	Average float64
+}
+
+// Stats returns a snapshot of m's statistics.
+func (m *Monitor) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := Stats{Periods: m.periods, Mean: m.mean, Peak: m.peak, Min: m.min}
+	if m.periods > 0 {
+		s.Elapsed = m.last.Time.Sub(m.started)
+	}
+	if s.Elapsed > 0 {
+		s.Average = float64(m.events) / s.Elapsed.Seconds()
+		if m.scale != 0 {
+			s.Average *= m.scale
+		}
+	}
+	if m.periods > 1 {
+		s.Variance = m.m2 / float64(m.periods-1)
+		s.StdDev = math.Sqrt(s.Variance)
+	}
+	return s
+}
+
+// WithWindow makes the Monitor retain the rates of the
+// last n periods so that statistics such as percentiles
+// can be computed over them.
+func WithWindow(n int) MonitorOption {
+	return func(m *Monitor) {
+		if n > 0 {
+			m.window = make([]float64, n)
+		}
+	}
+}
+
+// record records the report for a period in which
+// n events happened. m.mu must be held.
+func (m *Monitor) record(r Rate, n int64) {
+	m.last = r
+	for _, t := range m.tiers {
+		t.add(r, n)
+	}
+
+	rate := r.Rate
+	if m.periods == 0 {
+		m.started = r.Time.Add(-r.Interval)
+	}
+	m.events += n
+	if m.periods == 0 || rate > m.peak {
+		m.peak = rate
+	}
+	if rate != 0 && (m.min == 0 || rate < m.min) {
+		m.min = rate
+	}
+
+	// Welford's algorithm for the running variance.
+	m.periods++
+	d := rate - m.mean
+	m.mean += d / float64(m.periods)
+	m.m2 += d * (rate - m.mean)
+
+	if len(m.recent) > 0 {
+		m.recent[m.ri] = r
+		m.ri = (m.ri + 1) % len(m.recent)
+		if m.rn < len(m.recent) {
+			m.rn++
+		}
+	}
+
+	if len(m.window) == 0 {
+		return
+	}
+	m.window[m.wi] = rate
+	m.wi = (m.wi + 1) % len(m.window)
+	if m.wn < len(m.window) {
+		m.wn++
+	}
+}
+
+// Percentile returns the pth percentile (0 <= p <= 100)
+// of the rates reported over the window configured with
+// WithWindow, using the nearest-rank method. For example,
+// Percentile(95) is the rate used for 95th percentile
+// billing. If m has no window, or no periods have elapsed
+// yet, Percentile returns 0.
+func (m *Monitor) Percentile(p float64) float64 {
+	m.mu.Lock()
+	rates := make([]float64, m.wn)
+	copy(rates, m.window[:m.wn])
+	m.mu.Unlock()
+
+	if len(rates) == 0 {
+		return 0
+	}
+	sort.Float64s(rates)
+	i := int(math.Ceil(p/100*float64(len(rates)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(rates) {
+		i = len(rates) - 1
+	}
+	return rates[i]
+}
+
+// WithHistoryLen makes the Monitor retain the last n Rates
+// it computed, so that they can be retrieved with History;
+// for example, to draw a sparkline of the rate without a
+// goroutine to collect the Rates. It is independent of
+// WithHistory, which retains history over longer spans, at
+// coarser resolutions, for HistoryRange and Query.
+func WithHistoryLen(n int) MonitorOption {
+	return func(m *Monitor) {
+		if n > 0 {
+			m.recent = make([]Rate, n)
+		}
+	}
+}
+
+// History returns the Rates retained by m (see
+// WithHistoryLen), oldest first. If m retains no Rates,
+// or no periods have elapsed yet, History returns nil.
+func (m *Monitor) History() []Rate {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.rn == 0 {
+		return nil
+	}
+	rs := make([]Rate, 0, m.rn)
+	if m.rn == len(m.recent) {
+		rs = append(rs, m.recent[m.ri:]...)
+	}
+	return append(rs, m.recent[:m.ri]...)
+}
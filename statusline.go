@@ -0,0 +1,162 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// A StatusLine renders a single-line progress display,
+// suitable for a terminal, which is updated in place
+// each time a new Rate is reported. It is typically
+// used as the function passed to MakeMonitorFunc or
+// MakeMonitorReaderFunc:
+//
+//	s := rate.NewStatusLine(os.Stderr)
+//	s.Expected = size
+//	r := rate.MakeMonitorReaderFunc(f, 0, s.Update)
+//	...
+//	s.Finish()
+//
+// The fields of a StatusLine should not be modified
+// once Update has been called.
+type StatusLine struct {
+	// Expected is the total expected number of units.
+	// If it is nonzero, or the Monitor reporting the Rates
+	// tracks its progress (see WithExpected), the display
+	// includes a progress bar and an estimate of the time
+	// remaining, which is taken from the Monitor's if it
+	// has one.
+	Expected uint64
+	// Width is the width of the progress bar in characters.
+	// If it is 0, a width of 20 is used.
+	Width int
+	// Sparkline is the number of recent periods to show
+	// as a sparkline. If it is 0, no sparkline is shown.
+	Sparkline int
+
+	mu     sync.Mutex
+	w      io.Writer
+	recent []float64
+	ewma   float64 // the smoothed rate, for the ETA
+	init   bool    // whether ewma has been initialized
+	last   int     // width of the last line written
+}
+
+// NewStatusLine creates a new StatusLine which writes to w.
+func NewStatusLine(w io.Writer) *StatusLine {
+	return &StatusLine{w: w}
+}
+
+// Update redraws the status line to reflect r.
+func (s *StatusLine) Update(r Rate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Sparkline > 0 {
+		s.recent = append(s.recent, r.Rate)
+		if len(s.recent) > s.Sparkline {
+			s.recent = s.recent[len(s.recent)-s.Sparkline:]
+		}
+	}
+	if r.Expected == 0 && s.Expected > 0 {
+		// Estimate the ETA as a Monitor would.
+		if !s.init {
+			s.ewma, s.init = r.Rate, true
+		} else {
+			s.ewma = ewma(s.ewma, r.Rate, r.Interval, defaultETATau)
+		}
+		setProgress(&r, s.Expected, s.ewma)
+	}
+
+	line := s.render(r)
+	width := utf8.RuneCountInString(line)
+	pad := ""
+	if width < s.last {
+		pad = strings.Repeat(" ", s.last-width)
+	}
+	s.last = width
+	fmt.Fprint(s.w, "\r"+line+pad)
+}
+
+// Finish ends the status line, so that subsequent output
+// does not overwrite it.
+func (s *StatusLine) Finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last > 0 {
+		fmt.Fprintln(s.w)
+		s.last = 0
+	}
+}
+
+// render must be called with s.mu held.
+func (s *StatusLine) render(r Rate) string {
+	parts := []string{FormatBytes(float64(r.Total)), FormatBytes(r.Rate) + "/s"}
+	if s.Sparkline > 0 {
+		parts = append(parts, s.sparkline())
+	}
+	if r.Expected > 0 {
+		parts = append(parts, s.bar(r.Progress))
+		switch {
+		case r.Progress >= 1:
+			parts = append(parts, "done")
+		case r.ETA >= 0:
+			parts = append(parts, "ETA "+r.ETA.Round(time.Second).String())
+		default:
+			parts = append(parts, "ETA --")
+		}
+	}
+	return strings.Join(parts, "  ")
+}
+
+func (s *StatusLine) sparkline() string {
+	recent := s.recent
+	if len(recent) > s.Sparkline {
+		recent = recent[len(recent)-s.Sparkline:]
+	}
+	var max float64
+	for _, v := range recent {
+		if v > max {
+			max = v
+		}
+	}
+	spark := make([]rune, s.Sparkline)
+	for i := range spark {
+		spark[i] = ' '
+	}
+	off := s.Sparkline - len(recent)
+	for i, v := range recent {
+		j := 0
+		if max > 0 {
+			j = int(v / max * float64(len(sparks)-1))
+		}
+		spark[off+i] = sparks[j]
+	}
+	return string(spark)
+}
+
+func (s *StatusLine) bar(frac float64) string {
+	width := s.Width
+	if width == 0 {
+		width = 20
+	}
+	if frac > 1 {
+		frac = 1
+	}
+	full := int(frac * float64(width))
+	bar := strings.Repeat("=", full)
+	if full < width {
+		bar += ">" + strings.Repeat(" ", width-full-1)
+	}
+	return fmt.Sprintf("[%s] %3.0f%%", bar, frac*100)
+}
@@ -0,0 +1,44 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// TestStatusLine tests that a StatusLine estimates the
+// time remaining, and overwrites longer lines in full,
+// counting the runes of its sparkline rather than bytes.
+func TestStatusLine(t *testing.T) {
+	var b strings.Builder
+	s := NewStatusLine(&b)
+	s.Expected = 10000
+	s.Sparkline = 4
+	s.Update(Rate{Total: 2000, Rate: 2000, Interval: time.Second})
+	s.Update(Rate{Total: 2100, Rate: 100, Interval: time.Second})
+
+	lines := strings.Split(b.String(), "\r")[1:]
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines; want 2", len(lines))
+	}
+	if !strings.Contains(lines[0], "ETA 4s") {
+		t.Errorf("got line %q; want ETA 4s", lines[0])
+	}
+	if w0, w1 := utf8.RuneCountInString(lines[0]), utf8.RuneCountInString(lines[1]); w1 != w0 {
+		t.Errorf("got line of width %d after one of width %d; want it padded to %d", w1, w0, w0)
+	}
+
+	// The ETA of a Monitor which tracks its
+	// progress is used in place of s's own.
+	b.Reset()
+	s = NewStatusLine(&b)
+	s.Update(Rate{Total: 500, Rate: 100, Interval: time.Second, Expected: 1000, Progress: 0.5, ETA: 3 * time.Second})
+	if line := b.String(); !strings.Contains(line, " 50%") || !strings.Contains(line, "ETA 3s") {
+		t.Errorf("got line %q; want 50%% and ETA 3s", line)
+	}
+}
@@ -0,0 +1,608 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// copyChunk is the number of bytes counted at a time
+	// by ReadFrom and WriteTo.
+	copyChunk = 1 << 20
+
+	// limitChunk is the maximum number of bytes waited
+	// for at a time by Limiters other than *Pacer.
+	limitChunk = 32 << 10
+)
+
+// A taker is a Limiter which can consume as many
+// units as are available, and return those which
+// turn out not to be used.
+type taker interface {
+	take(ctx context.Context, max int) (int, error)
+	refund(n int)
+}
+
+// stream holds the state common to Readers and Writers.
+type stream struct {
+	l   Limiter
+	m   Counter
+	own bool // whether m is a *Monitor which belongs to the stream
+	err error
+
+	deadline time.Time
+	size, n  uint64 // bytes to transfer and transferred so far
+
+	idle time.Duration
+	user atomic.Value // time.Time; the deadline set by the user, if any
+
+	onErr func(e ErrorEvent)
+	ceil  *ceiling
+
+	ctx     context.Context // the context of waits for l, if set
+	conn    *connDeadline   // the deadline of the Conn, if any
+	bounded bool            // whether waits for l are bounded by maxWait
+	maxWait time.Duration
+
+	// bk is the budget taken for bytes read or written
+	// one at a time, and bn the number of those bytes
+	// transferred so far.
+	bk, bn int
+}
+
+// An Option configures a Reader or Writer.
+type Option func(s *stream)
+
+// WithLimiter limits the rate of the Reader or Writer
+// using l. The same *Pacer may be given to several
+// Readers and Writers to limit their combined rate.
+//
+// Limiters other than *Pacer are waited on in chunks of
+// at most 32KB. Writers wait before writing each chunk,
+// while Readers wait after reading, since they cannot
+// know in advance how many bytes a read will return.
+func WithLimiter(l Limiter) Option {
+	return func(s *stream) { s.l = l }
+}
+
+// WithLimit limits the rate of the Reader or Writer
+// to bps bytes per second, as though by a Pacer
+// created with NewPacer(bps, opts...).
+func WithLimit(bps uint64, opts ...LimitOption) Option {
+	return WithLimiter(NewPacer(bps, opts...))
+}
+
+// WithMonitor counts the bytes read or written using m.
+// Closing the Reader or Writer does not close m. If m
+// is nil, bytes are not counted.
+func WithMonitor(m *Monitor) Option {
+	if m == nil {
+		return WithCounter(nil)
+	}
+	return WithCounter(m)
+}
+
+// WithCounter counts the bytes read or written using c.
+func WithCounter(c Counter) Option {
+	return func(s *stream) { s.m = c }
+}
+
+// An ErrorEvent records a failed read from or write
+// to the stream underlying a Reader or Writer.
+type ErrorEvent struct {
+	Time time.Time
+	Err  error
+}
+
+// WithErrorFunc makes the Reader or Writer call f whenever
+// a read from or write to its underlying stream fails, so
+// that a supervisor can learn of failures without owning
+// the loop which reads or writes. Reaching the end of the
+// stream (io.EOF) is not a failure. f is called synchronously,
+// before the error is returned.
+func WithErrorFunc(f func(e ErrorEvent)) Option {
+	return func(s *stream) { s.onErr = f }
+}
+
+// WithErrorChannel makes the Reader or Writer send an
+// ErrorEvent on ch whenever a read from or write to its
+// underlying stream fails, as WithErrorFunc does. If ch is
+// not ready to receive, the event is dropped rather than
+// blocking the read or write.
+func WithErrorChannel(ch chan<- ErrorEvent) Option {
+	return WithErrorFunc(func(e ErrorEvent) {
+		select {
+		case ch <- e:
+		default:
+		}
+	})
+}
+
+// failed reports err to the stream's error
+// function, if it is a failure.
+func (s *stream) failed(err error) {
+	if s.onErr != nil && err != nil && err != io.EOF {
+		s.onErr(ErrorEvent{Time: time.Now(), Err: err})
+	}
+}
+
+func newStream(opts []Option) stream {
+	var s stream
+	for _, o := range opts {
+		o(&s)
+	}
+	return s
+}
+
+// take blocks until at least one of n bytes may be
+// transferred, and returns how many may be. If after
+// is true, and s's Limiter cannot return unused units,
+// take does not wait; the bytes are waited for in done
+// once it is known how many were transferred.
+func (s *stream) take(n int, after bool) (int, error) {
+	if err := s.feasible(); err != nil {
+		return 0, err
+	}
+	for {
+		ctx, stop, deadline := s.waitContext()
+		k, err := s.wait(ctx, n, after)
+		interrupted := stop()
+		if err == context.Canceled && interrupted && s.context().Err() == nil {
+			// The Conn's deadline was changed;
+			// wait again with the new one.
+			continue
+		}
+		if err == context.DeadlineExceeded && !deadline.IsZero() && s.context().Err() == nil {
+			return k, errDeadline
+		}
+		return k, s.budgetErr(err)
+	}
+}
+
+// wait is like take, but waits with ctx.
+func (s *stream) wait(ctx context.Context, n int, after bool) (int, error) {
+	switch l := s.l.(type) {
+	case nil:
+		return n, nil
+	case taker:
+		return l.take(ctx, n)
+	}
+	if n > limitChunk {
+		n = limitChunk
+	}
+	if after {
+		return n, nil
+	}
+	return n, s.l.WaitN(ctx, n)
+}
+
+// done records that n of the k bytes allowed by
+// take were actually transferred.
+func (s *stream) done(k, n int, after bool) error {
+	s.n += uint64(n)
+	if err := s.settle(k, n, after); err != nil {
+		return err
+	}
+	return s.police(n)
+}
+
+// settle is like done, but does not update s.n, so
+// that it may be called concurrently, as by ReadAt.
+func (s *stream) settle(k, n int, after bool) (err error) {
+	if t, ok := s.l.(taker); ok {
+		t.refund(k - n)
+	} else if after && s.l != nil && n > 0 {
+		err = s.l.WaitN(s.context(), n)
+	}
+	if s.m != nil {
+		s.m.Add(uint64(n))
+	}
+	return err
+}
+
+// Total returns the total number of bytes counted by the
+// stream's Counter, if it is a *Monitor (or otherwise has
+// a Total method), and 0 otherwise. If the Monitor is
+// shared with other streams, their bytes are included.
+// It may be called concurrently with reads and writes.
+func (s *stream) Total() uint64 {
+	if t, ok := s.m.(interface {
+		Total() uint64
+	}); ok {
+		return t.Total()
+	}
+	return 0
+}
+
+// CurrentRate returns the rate over the most recent period
+// of the stream's Counter, if it is a *Monitor (or otherwise
+// has a CurrentRate method), and 0 otherwise. It may be
+// called concurrently with reads and writes.
+func (s *stream) CurrentRate() float64 {
+	if r, ok := s.m.(interface {
+		CurrentRate() float64
+	}); ok {
+		return r.CurrentRate()
+	}
+	return 0
+}
+
+// SetRate changes the rate of the stream's Limiter to bps
+// bytes per second, if it is a *Pacer (or otherwise has a
+// SetRate method), so that a limit can be changed in the
+// middle of a transfer. If the Limiter is shared with other
+// streams, their combined rate is changed.
+func (s *stream) SetRate(bps uint64) {
+	if r, ok := s.l.(interface {
+		SetRate(bps uint64)
+	}); ok {
+		r.SetRate(bps)
+	}
+}
+
+// SetQuantum changes the quantum of the stream's Limiter,
+// if it is a *Pacer (or otherwise has a SetQuantum method).
+// If the Limiter is shared with other streams, their
+// quantum is changed.
+func (s *stream) SetQuantum(quantum time.Duration) {
+	if q, ok := s.l.(interface {
+		SetQuantum(quantum time.Duration)
+	}); ok {
+		q.SetQuantum(quantum)
+	}
+}
+
+// Waste reclassifies n of the bytes already counted
+// as wasted, if the stream's Counter is a *Monitor
+// (or otherwise has a Waste method). For example, an
+// upload tool can call Waste with the number of bytes
+// sent in an attempt which failed, so that the progress
+// reported reflects only the bytes sent in the attempt
+// which succeeded.
+func (s *stream) Waste(n uint64) {
+	if w, ok := s.m.(interface {
+		Waste(n uint64)
+	}); ok {
+		w.Waste(n)
+	}
+}
+
+// AddWasted counts n wasted bytes, such as bytes which
+// were retransmitted outside of the stream, if the
+// stream's Counter is a *Monitor (or otherwise has an
+// AddWasted method).
+func (s *stream) AddWasted(n uint64) {
+	if w, ok := s.m.(interface {
+		AddWasted(n uint64)
+	}); ok {
+		w.AddWasted(n)
+	}
+}
+
+// close closes the stream's Monitor if it owns it.
+func (s *stream) close() {
+	if s.own {
+		s.m.(*Monitor).Close()
+	}
+}
+
+// A Reader wraps an io.Reader, optionally limiting the
+// rate at which bytes are read from it, and monitoring
+// that rate.
+type Reader struct {
+	r io.Reader
+	stream
+}
+
+// NewReader returns a new Reader which reads from r. Unless
+// it is given WithLimiter, WithLimit, or WithMonitor, it
+// neither limits nor monitors its rate.
+func NewReader(r io.Reader, opts ...Option) *Reader {
+	return &Reader{r: r, stream: newStream(opts)}
+}
+
+func (m *Reader) Read(p []byte) (n int, err error) {
+	if m.err != nil {
+		n, err = 0, m.err
+		return
+	}
+	if err = m.flushBytes(); err != nil {
+		return
+	}
+	if m.r == nil {
+		n, err = 0, io.EOF
+		return
+	}
+	if len(p) == 0 {
+		return
+	}
+
+	k, err := m.take(len(p), true)
+	if err != nil {
+		return
+	}
+	armed := m.arm(m.r, true)
+	n, err = m.r.Read(p[:k])
+	err = idleErr(armed, err)
+	m.failed(err)
+	if werr := m.done(k, n, true); err == nil {
+		err = werr
+	}
+	return
+}
+
+// WriteTo implements io.WriterTo. If m neither limits its
+// rate nor has an idle timeout, and w implements
+// io.ReaderFrom (as *os.File and *net.TCPConn do), it is
+// used to copy from m's underlying Reader, so that wrapping
+// a Reader does not prevent io.Copy from using operating
+// system facilities such as sendfile. Bytes are counted,
+// and checked against any ceiling set with WithCeiling, in
+// chunks of up to 1MB as the copy progresses.
+func (m *Reader) WriteTo(w io.Writer) (n int64, err error) {
+	if m.err != nil {
+		n, err = 0, m.err
+		return
+	}
+	if err = m.flushBytes(); err != nil {
+		return
+	}
+	if m.r == nil {
+		return
+	}
+
+	if rf, ok := w.(io.ReaderFrom); ok && m.l == nil && m.idle <= 0 {
+		n, err = m.readFrom(rf, m.r)
+		m.failed(err)
+		return
+	}
+	n, err = io.Copy(w, ReaderOnly{m})
+	return
+}
+
+// Close closes the reader; all subsequent calls to Read
+// will return io.EOF or any error previously encountered,
+// and the rate will not be reported any more if m owns
+// its Monitor (that is, if it was created by one of the
+// MakeMonitorReader functions). Additionally, if m's
+// underlying Reader implements the io.ReadCloser
+// interface, its Close method will be called, and its
+// return value will be returned from this method.
+//
+// If m's underlying writer implements io.ReadCloser,
+// but it's undesirable for its Close method to be called,
+// wrap it in a ReaderOnly before creating m.
+func (m *Reader) Close() error {
+	m.flushBytes()
+	m.close()
+	defer func() { m.r = nil }()
+	if rc, ok := m.r.(io.ReadCloser); ok {
+		return rc.Close()
+	}
+	return nil
+}
+
+// SetReader replaces m's underlying Reader with r, for
+// example after reconnecting, or once a log file has been
+// rotated. The total and rate history of m's Monitor, and
+// the budget of its Limiter, carry over, so that the rate
+// is limited and reported as though the stream had never
+// been interrupted. SetReader reopens m if it was closed
+// (though a Monitor owned by m is not restarted), but
+// does not close the Reader it replaces. It must not
+// be called concurrently with Read.
+func (m *Reader) SetReader(r io.Reader) {
+	m.r = r
+}
+
+// A Writer wraps an io.Writer, optionally limiting the
+// rate at which bytes are written to it, and monitoring
+// that rate.
+type Writer struct {
+	w io.Writer
+	stream
+}
+
+// NewWriter returns a new Writer which writes to w. Unless
+// it is given WithLimiter, WithLimit, or WithMonitor, it
+// neither limits nor monitors its rate.
+//
+// If the Writer limits its rate, and Write is called with
+// more bytes than its limiter allows at once, it writes as
+// many as are allowed and sleeps repeatedly until all of
+// the bytes have been written.
+func NewWriter(w io.Writer, opts ...Option) *Writer {
+	return &Writer{w: w, stream: newStream(opts)}
+}
+
+func (m *Writer) Write(p []byte) (n int, err error) {
+	if m.err != nil {
+		n, err = 0, m.err
+		return
+	}
+	if err = m.flushBytes(); err != nil {
+		return
+	}
+	if m.w == nil {
+		n, err = 0, io.EOF
+		return
+	}
+
+	for len(p) > 0 {
+		var k, nn int
+		k, err = m.take(len(p), false)
+		if err != nil {
+			return
+		}
+		armed := m.arm(m.w, false)
+		nn, err = m.w.Write(p[:k])
+		err = idleErr(armed, err)
+		m.failed(err)
+		if derr := m.done(k, nn, false); err == nil {
+			err = derr
+		}
+		n += nn
+		if err != nil {
+			return
+		}
+		p = p[nn:]
+	}
+	return
+}
+
+// ReadFrom implements io.ReaderFrom. If m neither limits its
+// rate nor has an idle timeout, and m's underlying Writer
+// implements io.ReaderFrom (as *os.File and *net.TCPConn
+// do), it is used to copy from r, so that wrapping a Writer
+// does not prevent io.Copy from using operating system
+// facilities such as sendfile. Bytes are counted, and
+// checked against any ceiling set with WithCeiling, in
+// chunks of up to 1MB as the copy progresses.
+func (m *Writer) ReadFrom(r io.Reader) (n int64, err error) {
+	if m.err != nil {
+		n, err = 0, m.err
+		return
+	}
+	if err = m.flushBytes(); err != nil {
+		return
+	}
+	if m.w == nil {
+		n, err = 0, io.EOF
+		return
+	}
+
+	if rf, ok := m.w.(io.ReaderFrom); ok && m.l == nil && m.idle <= 0 {
+		n, err = m.readFrom(rf, r)
+		m.failed(err)
+		return
+	}
+	n, err = io.Copy(WriterOnly{m}, r)
+	return
+}
+
+// readFrom copies from r to rf in chunks, counting each
+// one in s's Monitor, and checking it against s's ceiling.
+// Because *io.LimitedReader is special cased by the fast
+// paths of the standard library, this preserves them.
+func (s *stream) readFrom(rf io.ReaderFrom, r io.Reader) (n int64, err error) {
+	for {
+		var nn int64
+		nn, err = rf.ReadFrom(&io.LimitedReader{R: r, N: copyChunk})
+		n += nn
+		s.n += uint64(nn)
+		if s.m != nil {
+			s.m.Add(uint64(nn))
+		}
+		if perr := s.police(int(nn)); err == nil {
+			err = perr
+		}
+		if err != nil || nn < copyChunk {
+			return
+		}
+	}
+}
+
+// Close closes the writer; all subsequent calls to Write
+// will return io.EOF or any error previously encountered,
+// and the rate will not be reported any more if m owns
+// its Monitor (that is, if it was created by one of the
+// MakeMonitorWriter functions). Additionally, if m's
+// underlying Writer implements the io.WriteCloser
+// interface, its Close method will be called, and its
+// return value will be returned from this method.
+//
+// If m's underlying writer implements io.WriteCloser,
+// but it's undesirable for its Close method to be called,
+// wrap it in a WriterOnly before creating m.
+func (m *Writer) Close() error {
+	m.flushBytes()
+	m.close()
+	defer func() { m.w = nil }()
+	if wc, ok := m.w.(io.WriteCloser); ok {
+		return wc.Close()
+	}
+	return nil
+}
+
+// SetWriter replaces m's underlying Writer with w, for
+// example after reconnecting, or once a log file has been
+// rotated. The total and rate history of m's Monitor, and
+// the budget of its Limiter, carry over, so that the rate
+// is limited and reported as though the stream had never
+// been interrupted. SetWriter reopens m if it was closed
+// (though a Monitor owned by m is not restarted), but
+// does not close the Writer it replaces. It must not
+// be called concurrently with Write.
+func (m *Writer) SetWriter(w io.Writer) {
+	m.w = w
+}
+
+// A MonitorReader wraps an io.Reader and monitors the rate
+// at which bytes are read from it. Every period, the average
+// rate at which bytes were read over the preceding period
+// and the total number of bytes read so far are either written
+// to a channel, or passed as the argument to a function.
+//
+// Deprecated: Use Reader.
+type MonitorReader = Reader
+
+// MakeMonitorReader creates a new MonitorReader which writes
+// the rate and total to the returned channel every period.
+// If period == 0, the default period of 500ms will be used.
+//
+// Deprecated: Use NewReader with WithMonitor.
+func MakeMonitorReader(r io.Reader, period time.Duration, opts ...MonitorOption) (*MonitorReader, <-chan Rate) {
+	m, rch := MakeMonitor(period, opts...)
+	ret := NewReader(r, WithMonitor(m))
+	ret.own = true
+	return ret, rch
+}
+
+// MakeMonitorReaderFunc creates a new MonitorReader which calls
+// f in a separate goroutine every period. If period == 0, the
+// default period of 500ms will be used.
+//
+// Deprecated: Use NewReader with WithMonitor.
+func MakeMonitorReaderFunc(r io.Reader, period time.Duration, f func(r Rate), opts ...MonitorOption) *MonitorReader {
+	ret := NewReader(r, WithMonitor(MakeMonitorFunc(period, f, opts...)))
+	ret.own = true
+	return ret
+}
+
+// A MonitorWriter wraps an io.Writer and monitors the rate
+// at which bytes are written to it, Every period, the average
+// rate at which bytes were written over the preceding period
+// and the total number of bytes written so far are either
+// written to a channel, or passed as the argument to a function.
+//
+// Deprecated: Use Writer.
+type MonitorWriter = Writer
+
+// MakeMonitorWriter creates a new MonitorWriter which writes
+// the rate and total to the returned channel every period.
+// If period == 0, the default period of 500ms will be used.
+//
+// Deprecated: Use NewWriter with WithMonitor.
+func MakeMonitorWriter(w io.Writer, period time.Duration, opts ...MonitorOption) (*MonitorWriter, <-chan Rate) {
+	m, rch := MakeMonitor(period, opts...)
+	ret := NewWriter(w, WithMonitor(m))
+	ret.own = true
+	return ret, rch
+}
+
+// MakeMonitorWriterFunc creates a new MonitorWriter which calls
+// f in a separate goroutine every period. If period == 0, the
+// default period of 500ms will be used.
+//
+// Deprecated: Use NewWriter with WithMonitor.
+func MakeMonitorWriterFunc(w io.Writer, period time.Duration, f func(r Rate), opts ...MonitorOption) *MonitorWriter {
+	ret := NewWriter(w, WithMonitor(MakeMonitorFunc(period, f, opts...)))
+	ret.own = true
+	return ret
+}
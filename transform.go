@@ -0,0 +1,122 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"io"
+	"time"
+)
+
+// A TransformRate reports the rates on both sides
+// of a transform over the same period.
+type TransformRate struct {
+	// In and Out are the rates at which units enter
+	// and leave the transform.
+	In, Out Rate
+	// Ratio is Out.Total / In.Total; for a compressor,
+	// this is the compression ratio so far. If no units
+	// have entered the transform, Ratio is 0.
+	Ratio float64
+}
+
+// A TransformMonitor monitors both sides of a transform,
+// such as a compressor or an encoder, reporting the rates
+// at which units enter and leave it on the same ticks.
+//
+// For example, to monitor a gzip.Writer:
+//
+//	t := rate.MakeTransformMonitorFunc(0, f)
+//	zw := gzip.NewWriter(t.OutWriter(file))
+//	w := t.InWriter(zw)
+//	// Write to w, and then close it (which also closes zw).
+//	t.Close()
+type TransformMonitor struct {
+	in, out *Monitor
+	f       func(r TransformRate)
+	period  time.Duration
+	exit    chan struct{}
+}
+
+// MakeTransformMonitor creates a new TransformMonitor which
+// writes the rates to the returned channel every period. If
+// period == 0, the default period of 500ms will be used.
+func MakeTransformMonitor(period time.Duration, opts ...MonitorOption) (*TransformMonitor, <-chan TransformRate) {
+	rch := make(chan TransformRate, 8)
+	return MakeTransformMonitorFunc(period, func(r TransformRate) {
+		rch <- r
+	}, opts...), rch
+}
+
+// MakeTransformMonitorFunc creates a new TransformMonitor
+// which calls f in a separate goroutine every period. If
+// period == 0, the default period of 500ms will be used.
+//
+// The options are applied to the monitors for both sides
+// of the transform, so options which carry state, such as
+// WithDetector, should not be used.
+func MakeTransformMonitorFunc(period time.Duration, f func(r TransformRate), opts ...MonitorOption) *TransformMonitor {
+	t := &TransformMonitor{
+		in:   newMonitor(prepend(opts, WithPeriod(period))),
+		out:  newMonitor(prepend(opts, WithPeriod(period))),
+		f:    f,
+		exit: make(chan struct{}, 1),
+	}
+	t.period = t.in.period
+	go t.monitor()
+	return t
+}
+
+func (t *TransformMonitor) monitor() {
+	t1 := time.Now()
+	t.in.t0, t.out.t0 = t1, t1
+	for {
+		select {
+		case <-t.exit:
+			return
+		default:
+			time.Sleep(t.period)
+			select {
+			case <-t.exit:
+				return
+			default:
+			}
+
+			t1 := time.Now()
+			in, ok1 := t.in.tick(t1)
+			out, ok2 := t.out.tick(t1)
+			if !ok1 || !ok2 {
+				continue
+			}
+			r := TransformRate{In: in, Out: out}
+			if in.Total > 0 {
+				r.Ratio = float64(out.Total) / float64(in.Total)
+			}
+			t.f(r)
+		}
+	}
+}
+
+// In and Out return the monitors for the input and
+// output sides of the transform. Calling Add on them
+// counts units, but they do not report on their own.
+func (t *TransformMonitor) In() *Monitor  { return t.in }
+func (t *TransformMonitor) Out() *Monitor { return t.out }
+
+// InReader, InWriter, OutReader, and OutWriter wrap
+// r or w so that bytes read or written through them
+// are counted on the corresponding side of t. Closing
+// the returned wrappers does not stop t.
+func (t *TransformMonitor) InReader(r io.Reader) *Reader  { return NewReader(r, WithMonitor(t.in)) }
+func (t *TransformMonitor) InWriter(w io.Writer) *Writer  { return NewWriter(w, WithMonitor(t.in)) }
+func (t *TransformMonitor) OutReader(r io.Reader) *Reader { return NewReader(r, WithMonitor(t.out)) }
+func (t *TransformMonitor) OutWriter(w io.Writer) *Writer { return NewWriter(w, WithMonitor(t.out)) }
+
+// Close stops t from monitoring its rates.
+func (t *TransformMonitor) Close() {
+	select {
+	case t.exit <- struct{}{}:
+	default:
+	}
+}
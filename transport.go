@@ -0,0 +1,108 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"io"
+	"net/http"
+)
+
+// A Transport is an http.RoundTripper which limits and
+// monitors the rates at which request bodies are sent and
+// response bodies are received, both for all requests
+// together, and for each request individually:
+//
+//	sent, received := rate.NewMonitor(), rate.NewMonitor()
+//	client := &http.Client{Transport: &rate.Transport{
+//		Send:    []rate.Option{rate.WithMonitor(sent)},
+//		Receive: []rate.Option{rate.WithLimit(10 << 20), rate.WithMonitor(received)},
+//	}}
+//
+// Only bodies are limited and counted, not headers. The
+// fields of a Transport should not be modified while it
+// is in use.
+type Transport struct {
+	// Base is the RoundTripper which makes requests. If
+	// it is nil, http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	// Send and Receive configure the request and response
+	// bodies of all requests. A Limiter or Monitor given
+	// here is shared by all of them, and so limits or
+	// counts them in aggregate.
+	Send, Receive []Option
+
+	// PerRequest, if non-nil, is called for each request,
+	// and returns options which configure its request and
+	// response bodies alone, after Send and Receive. If
+	// both give a Limiter, both limits apply, and if both
+	// give a Monitor (or other Counter), the bytes are
+	// counted by both.
+	PerRequest func(req *http.Request) (send, receive []Option)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	send, receive := t.Send, t.Receive
+	if t.PerRequest != nil {
+		s, r := t.PerRequest(req)
+		send, receive = layerOpts(send, s), layerOpts(receive, r)
+	}
+
+	if req.Body != nil && req.Body != http.NoBody {
+		// A RoundTripper must not modify the request.
+		r := new(http.Request)
+		*r = *req
+		r.Body = newBody(req.Body, send)
+		if req.GetBody != nil {
+			r.GetBody = func() (io.ReadCloser, error) {
+				b, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				return newBody(b, send), nil
+			}
+		}
+		req = r
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Body != nil {
+		resp.Body = newBody(resp.Body, receive)
+	}
+	return resp, nil
+}
+
+// layerOpts is like cmdOpts, but if both all and own give
+// a Limiter, the stream is limited by both.
+func layerOpts(all, own []Option) []Option {
+	opts := cmdOpts(all, own)
+	a, o := newStream(all).l, newStream(own).l
+	if a != nil && o != nil && a != o {
+		opts = append(opts, WithLimiter(Compose(o, a)))
+	}
+	return opts
+}
+
+// A body is a request or response body read through a
+// Reader. It is closed directly, rather than through the
+// Reader, since http.Transport may close a request body
+// while another goroutine is reading it.
+type body struct {
+	*Reader
+	c io.Closer
+}
+
+func newBody(rc io.ReadCloser, opts []Option) body {
+	return body{NewReader(ReaderOnly{rc}, opts...), rc}
+}
+
+func (b body) Close() error { return b.c.Close() }
@@ -0,0 +1,204 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// A TreeCopier copies directory trees, limiting the aggregate
+// rate at which the files are copied, and monitoring the
+// progress of the copy as a whole. It is intended for copies
+// which must not starve other users of the disk or network,
+// such as backups:
+//
+//	m := rate.NewMonitor(rate.WithFunc(status.Update))
+//	c := &rate.TreeCopier{Limiter: rate.NewPacer(20 << 20), Monitor: m, Sync: true}
+//	err := c.Copy(ctx, "/backup/home", "/home")
+//
+// The fields of a TreeCopier should not be modified
+// while it is in use.
+type TreeCopier struct {
+	// Concurrency is the number of files copied
+	// at once; the default is 1.
+	Concurrency int
+	// Sync, if true, skips files which exist in the
+	// destination with the same size and modification
+	// time as in the source, so that a copy which was
+	// interrupted, or which is repeated, only copies
+	// the files which changed. Files in the destination
+	// which are not in the source are left alone.
+	Sync bool
+
+	// Limiter, if non-nil, limits the aggregate rate of
+	// the copy, and Monitor, if non-nil, counts its
+	// bytes.
+	Limiter Limiter
+	Monitor *Monitor
+
+	// OnSize, if non-nil, is called with the total size
+	// of the files to be copied once it is known, before
+	// any of them are copied, so that progress can be
+	// reported (see StatusLine.Expected).
+	OnSize func(size int64)
+}
+
+type treeFile struct {
+	src, dst string
+	fi       os.FileInfo
+}
+
+// Copy copies the tree rooted at src to dst, creating dst if
+// it does not exist. Directories, regular files, and symbolic
+// links are copied, along with their permissions; regular
+// files also keep their modification times. Other files,
+// such as devices and sockets, are skipped.
+//
+// If copying any file fails, or ctx is done, no more files
+// are started, the copies in progress are stopped, and Copy
+// returns the first error once they have returned.
+func (c *TreeCopier) Copy(ctx context.Context, dst, src string) error {
+	var files []treeFile
+	var size int64
+	err := filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		to := filepath.Join(dst, rel)
+		switch mode := fi.Mode(); {
+		case mode.IsDir():
+			return os.MkdirAll(to, mode.Perm())
+		case mode&os.ModeSymlink != 0:
+			return copySymlink(to, path)
+		case mode.IsRegular():
+			if c.Sync && unchanged(to, fi) {
+				return nil
+			}
+			files = append(files, treeFile{src: path, dst: to, fi: fi})
+			size += fi.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if c.OnSize != nil {
+		c.OnSize(size)
+	}
+
+	n := c.Concurrency
+	if n <= 0 {
+		n = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ch := make(chan treeFile)
+	go func() {
+		defer close(ch)
+		for _, f := range files {
+			select {
+			case ch <- f:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var first error
+	opts := []Option{WithLimiter(c.Limiter), WithMonitor(c.Monitor)}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range ch {
+				if err := copyFile(ctx, f, opts); err != nil {
+					once.Do(func() {
+						first = err
+						cancel()
+					})
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if first == nil {
+		first = ctx.Err()
+	}
+	return first
+}
+
+// unchanged returns whether the file at path
+// has the same size and modification time as fi.
+func unchanged(path string, fi os.FileInfo) bool {
+	dfi, err := os.Lstat(path)
+	return err == nil && dfi.Mode().IsRegular() &&
+		dfi.Size() == fi.Size() && dfi.ModTime().Equal(fi.ModTime())
+}
+
+func copySymlink(dst, src string) error {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	if cur, err := os.Readlink(dst); err == nil && cur == target {
+		return nil
+	}
+	os.Remove(dst)
+	return os.Symlink(target, dst)
+}
+
+// copyFile copies f through a Reader configured with
+// opts. The modification time is set only once the
+// copy is complete, so that a partial copy is never
+// mistaken for an unchanged file.
+func copyFile(ctx context.Context, f treeFile, opts []Option) (err error) {
+	in, err := os.Open(f.src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(f.dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, NewReader(ctxReader{ctx, in}, opts...))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(f.dst, f.fi.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(f.dst, f.fi.ModTime(), f.fi.ModTime())
+}
+
+// A ctxReader stops reading once its context is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,106 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+// Upload performs a chunked upload (such as an S3 multipart
+// upload) of the contents of r. It reads r in parts of
+// partSize bytes (the last may be shorter), and uploads each
+// one by calling upload with a body which reads the part's
+// bytes, using up to n concurrent calls. The rate at which
+// the bodies are read is limited and monitored as a whole
+// according to opts, so that the upload's aggregate rate
+// stays under the limit, and its progress is reported as
+// that of a single transfer.
+//
+// If upload returns an error, the part is retried up to
+// retries times. The bytes read by failed attempts are
+// reclassified as wasted (see Monitor.Waste), so that the
+// progress reported reflects only the bytes which were
+// uploaded successfully. If a part fails every attempt,
+// or reading r fails, the context passed to the calls in
+// progress is canceled, and Upload returns the error once
+// they have returned.
+//
+// Up to n parts are held in memory at once.
+func Upload(ctx context.Context, r io.Reader, partSize int64, n, retries int, upload func(ctx context.Context, p Part, body io.Reader) error, opts ...Option) error {
+	if partSize <= 0 {
+		partSize = copyChunk
+	}
+	if n <= 0 {
+		n = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var first error
+	fail := func(err error) {
+		once.Do(func() {
+			first = err
+			cancel()
+		})
+	}
+	sem := make(chan struct{}, n)
+
+parts:
+	for i, off := 0, int64(0); ; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			fail(ctx.Err())
+			break parts
+		}
+		buf := make([]byte, partSize)
+		k, err := io.ReadFull(r, buf)
+		if k > 0 {
+			p := Part{Index: i, Offset: off, Size: int64(k), opts: opts, ctx: ctx}
+			off += int64(k)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := uploadPart(ctx, p, buf[:k], retries, upload); err != nil {
+					fail(err)
+				}
+				<-sem
+			}()
+		} else {
+			<-sem
+		}
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			break parts
+		default:
+			fail(err)
+			break parts
+		}
+	}
+	wg.Wait()
+	return first
+}
+
+// uploadPart uploads a single part, retrying
+// up to retries times.
+func uploadPart(ctx context.Context, p Part, buf []byte, retries int, upload func(ctx context.Context, p Part, body io.Reader) error) error {
+	for attempt := 0; ; attempt++ {
+		body := p.Reader(bytes.NewReader(buf))
+		err := upload(ctx, p, body)
+		if err == nil {
+			return nil
+		}
+		body.Waste(body.n)
+		if attempt >= retries || ctx.Err() != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// TestUploadRetryWaste tests that the bytes of a failed
+// attempt to upload a part are counted as wasted, even
+// when the upload copies them with io.Copy into an
+// io.ReaderFrom, which bypasses Read.
+func TestUploadRetryWaste(t *testing.T) {
+	f, err := ioutil.TempFile("", "rate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	m := NewMonitor()
+	defer m.Close()
+	var attempts int
+	err = Upload(context.Background(), bytes.NewReader(make([]byte, 1000)), 1000, 1, 1, func(ctx context.Context, p Part, body io.Reader) error {
+		attempts++
+		if _, err := io.Copy(f, body); err != nil {
+			return err
+		}
+		if attempts == 1 {
+			return errors.New("injected failure")
+		}
+		return nil
+	}, WithMonitor(m))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("got %d attempts; want 2", attempts)
+	}
+	if got := m.Total(); got != 1000 {
+		t.Errorf("got total %d; want 1000", got)
+	}
+	if got := m.Snapshot().Wasted; got != 1000 {
+		t.Errorf("got %d wasted; want 1000", got)
+	}
+}
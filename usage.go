@@ -0,0 +1,213 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Labels identify what a count of events is attributed
+// to, such as a customer or a bucket.
+type Labels map[string]string
+
+// key returns a string which is equal for
+// equal sets of labels.
+func (l Labels) key() string {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte(0)
+		b.WriteString(l[k])
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// clone returns a copy of l, or nil if l is empty.
+func (l Labels) clone() Labels {
+	if len(l) == 0 {
+		return nil
+	}
+	c := make(Labels, len(l))
+	for k, v := range l {
+		c[k] = v
+	}
+	return c
+}
+
+// A UsageRecord records the number of events attributed
+// to a set of labels during a window of time.
+type UsageRecord struct {
+	Labels Labels
+	// N is the number of events which happened in
+	// the window [Start, End).
+	N          uint64
+	Start, End time.Time
+}
+
+// An Accountant produces usage records suitable for metered
+// billing. Events are counted by the Counters returned by its
+// Counter method, and every period, the Accountant emits one
+// UsageRecord for each set of labels whose count changed
+// during the period.
+//
+// Records are delivered at least once: they are passed to
+// the flush function given to NewAccountant, and if it
+// returns an error, they are passed again, along with any
+// newer records, at the end of the next period. Consumers
+// which must not double count should deduplicate records
+// by their labels and Start time.
+type Accountant struct {
+	period time.Duration
+	flush  func(recs []UsageRecord) error
+
+	mu       sync.Mutex
+	counters map[string]*usageCounter
+	start    time.Time
+	pending  []UsageRecord
+	err      error
+
+	exit chan struct{}
+	done chan struct{}
+}
+
+type usageCounter struct {
+	// n is accessed atomically, and so
+	// must be 64-bit aligned.
+	n      uint64
+	last   uint64 // n as of the last period
+	labels Labels
+}
+
+func (u *usageCounter) Add(n uint64) { atomic.AddUint64(&u.n, n) }
+
+// NewAccountant creates a new Accountant which passes the
+// usage records for each period to flush. If period == 0,
+// the default period of 500ms will be used, though billing
+// pipelines will usually want a much longer one.
+func NewAccountant(period time.Duration, flush func(recs []UsageRecord) error) *Accountant {
+	if period == 0 {
+		period = defaultPeriod
+	}
+	a := &Accountant{
+		period:   period,
+		flush:    flush,
+		counters: make(map[string]*usageCounter),
+		start:    time.Now(),
+		exit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go a.account()
+	return a
+}
+
+// Counter returns a Counter which attributes the events
+// it counts to labels. Calls with equal labels return the
+// same Counter. labels must not be modified afterwards.
+func (a *Accountant) Counter(labels Labels) Counter {
+	k := labels.key()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u, ok := a.counters[k]
+	if !ok {
+		u = &usageCounter{labels: labels}
+		a.counters[k] = u
+	}
+	return u
+}
+
+func (a *Accountant) account() {
+	defer close(a.done)
+	t := time.NewTicker(a.period)
+	defer t.Stop()
+	for {
+		select {
+		case <-a.exit:
+			return
+		case now := <-t.C:
+			a.mu.Lock()
+			a.collect(now)
+			a.mu.Unlock()
+			a.deliver()
+		}
+	}
+}
+
+// collect ends the current window at end, and appends
+// a record for each Counter which counted events during
+// it to the pending records. a.mu must be held.
+func (a *Accountant) collect(end time.Time) {
+	for _, u := range a.counters {
+		n := atomic.LoadUint64(&u.n)
+		if n == u.last {
+			continue
+		}
+		a.pending = append(a.pending, UsageRecord{
+			Labels: u.labels,
+			N:      n - u.last,
+			Start:  a.start,
+			End:    end,
+		})
+		u.last = n
+	}
+	a.start = end
+}
+
+// deliver passes the pending records to the flush
+// function, and discards them if it succeeds. a.mu
+// must not be held, so that events can be counted
+// by new Counters while the records are flushed.
+func (a *Accountant) deliver() {
+	a.mu.Lock()
+	recs := a.pending
+	a.pending = nil
+	a.mu.Unlock()
+	if len(recs) == 0 {
+		return
+	}
+	err := a.flush(recs)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err = err; err != nil {
+		// Records collected in the meantime
+		// are newer than those which failed.
+		a.pending = append(recs, a.pending...)
+	}
+}
+
+// Close stops the Accountant, emits the records for the
+// final, partial period, and makes a last attempt to
+// deliver all pending records. It returns the error
+// returned by that attempt, if any, in which case the
+// records which could not be delivered are returned too,
+// so that the caller can persist them.
+func (a *Accountant) Close() ([]UsageRecord, error) {
+	a.mu.Lock()
+	select {
+	case <-a.exit:
+	default:
+		close(a.exit)
+	}
+	a.mu.Unlock()
+	<-a.done
+
+	a.mu.Lock()
+	a.collect(time.Now())
+	a.mu.Unlock()
+	a.deliver()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.pending, a.err
+}
@@ -0,0 +1,76 @@
+// Copyright 2014 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rate
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestAccountantSlowFlush tests that Counters can be
+// created while an Accountant's flush function is slow,
+// and that records which fail to flush are delivered
+// again, before newer ones.
+func TestAccountantSlowFlush(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		flushed []UsageRecord
+		calls   int
+	)
+	block := make(chan struct{})
+	a := NewAccountant(10*time.Millisecond, func(recs []UsageRecord) error {
+		mu.Lock()
+		calls++
+		first := calls == 1
+		mu.Unlock()
+		if first {
+			<-block
+			return errors.New("flush failed")
+		}
+		mu.Lock()
+		flushed = append(flushed, recs...)
+		mu.Unlock()
+		return nil
+	})
+	a.Counter(Labels{"customer": "a"}).Add(10)
+
+	// Wait for the first flush to begin.
+	for {
+		mu.Lock()
+		n := calls
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	done := make(chan struct{})
+	go func() {
+		a.Counter(Labels{"customer": "b"}).Add(20)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Counter blocked while records were flushed")
+	}
+	close(block)
+
+	pending, err := a.Close()
+	if len(pending) != 0 || err != nil {
+		t.Fatalf("Close returned %d pending records, %v; want none", len(pending), err)
+	}
+	if len(flushed) != 2 {
+		t.Fatalf("got %d records; want 2", len(flushed))
+	}
+	if c := flushed[0].Labels["customer"]; c != "a" || flushed[0].N != 10 {
+		t.Errorf("got first record %v; want 10 events for customer a", flushed[0])
+	}
+	if c := flushed[1].Labels["customer"]; c != "b" || flushed[1].N != 20 {
+		t.Errorf("got second record %v; want 20 events for customer b", flushed[1])
+	}
+}